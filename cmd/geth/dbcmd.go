@@ -0,0 +1,313 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	dbPrefixFlag = cli.StringFlag{
+		Name:  "prefix",
+		Usage: "Restrict the operation to keys with this prefix (0x-prefixed hex or plain text)",
+	}
+	dbRangeFlag = cli.StringFlag{
+		Name:  "range",
+		Usage: "Only compact keys with this prefix (0x-prefixed hex or plain text)",
+	}
+	dbDryRunFlag = cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Only print the upgrades that would be run",
+	}
+	dbCommand = cli.Command{
+		Name:     "db",
+		Usage:    "Low level database operations",
+		Category: "DATABASE COMMANDS",
+		Description: `
+The db commands operate directly on the key-value store of chaindata
+directories, independently of a running node.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "diff",
+				Usage:     "Compare the contents of two chaindata directories",
+				ArgsUsage: "<chaindataA> <chaindataB>",
+				Action:    utils.MigrateFlags(dbDiff),
+				Flags: []cli.Flag{
+					utils.CacheFlag,
+					dbPrefixFlag,
+				},
+				Description: `
+    geth db diff [--prefix <prefix>] <chaindataA> <chaindataB>
+
+iterates both databases in key order and prints every key present in only
+one of them, as well as every key whose value differs. Keys are decoded
+according to the chain database schema where possible. The command exits
+with an error if any difference is found.
+
+Both databases are opened read-only, but they can't be in use by a running
+node, which holds an exclusive lock on its database.`,
+			},
+			{
+				Name:      "compact",
+				Usage:     "Compact the chain database",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(dbCompact),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.SyncModeFlag,
+					dbRangeFlag,
+				},
+				Description: `
+    geth db compact [--range <prefix>]
+
+runs a full LevelDB compaction over the chain database of the node, or over
+the keys with the given prefix only, discarding deleted and overwritten data.
+The size of the database is reported before and after compaction.`,
+			},
+			{
+				Name:      "migrate-schema",
+				Usage:     "Upgrade the chain database to the current schema version",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(dbMigrateSchema),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.SyncModeFlag,
+					dbDryRunFlag,
+				},
+				Description: `
+    geth db migrate-schema [--dry-run]
+
+reads the schema version of the node's chain database and runs the registered
+upgrades needed to bring it to the version supported by this build. With
+--dry-run the pending upgrades are listed without modifying the database.`,
+			},
+		},
+	}
+)
+
+// openChaindata opens an existing chaindata directory in read-only mode, refusing
+// to create a new (empty) database in its place and to modify the existing one.
+func openChaindata(ctx *cli.Context, path string) *leveldb.DB {
+	db, err := leveldb.OpenFile(path, &opt.Options{
+		BlockCacheCapacity:     ctx.GlobalInt(utils.CacheFlag.Name) / 4 * opt.MiB,
+		OpenFilesCacheCapacity: 256,
+		ErrorIfMissing:         true,
+		ReadOnly:               true,
+	})
+	if err != nil {
+		utils.Fatalf("Failed to open chaindata %s: %v", path, err)
+	}
+	return db
+}
+
+// parseKeyPrefix interprets a key prefix given on the command line, either as
+// 0x-prefixed hex or as plain text.
+func parseKeyPrefix(prefix string) []byte {
+	if strings.HasPrefix(prefix, "0x") {
+		key, err := hexutil.Decode(prefix)
+		if err != nil {
+			utils.Fatalf("Invalid hex key prefix %q: %v", prefix, err)
+		}
+		return key
+	}
+	return []byte(prefix)
+}
+
+func dbDiff(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		utils.Fatalf("This command requires two chaindata directories as arguments.")
+	}
+	dbA := openChaindata(ctx, ctx.Args().Get(0))
+	defer dbA.Close()
+	dbB := openChaindata(ctx, ctx.Args().Get(1))
+	defer dbB.Close()
+
+	prefix := util.BytesPrefix(parseKeyPrefix(ctx.String(dbPrefixFlag.Name)))
+
+	itA := dbA.NewIterator(prefix, nil)
+	defer itA.Release()
+	itB := dbB.NewIterator(prefix, nil)
+	defer itB.Release()
+
+	// Walk the two sorted key sets side by side, reporting any divergence
+	var (
+		start                       = time.Now()
+		same, onlyA, onlyB, changed int
+	)
+	okA, okB := itA.Next(), itB.Next()
+	for okA || okB {
+		var cmp int
+		switch {
+		case !okB:
+			cmp = -1
+		case !okA:
+			cmp = 1
+		default:
+			cmp = bytes.Compare(itA.Key(), itB.Key())
+		}
+		switch {
+		case cmp < 0:
+			fmt.Printf("only in A: %s\n", rawdb.DescribeKey(itA.Key()))
+			onlyA++
+			okA = itA.Next()
+		case cmp > 0:
+			fmt.Printf("only in B: %s\n", rawdb.DescribeKey(itB.Key()))
+			onlyB++
+			okB = itB.Next()
+		default:
+			if bytes.Equal(itA.Value(), itB.Value()) {
+				same++
+			} else {
+				fmt.Printf("mismatch:  %s\n", rawdb.DescribeKey(itA.Key()))
+				changed++
+			}
+			okA, okB = itA.Next(), itB.Next()
+		}
+	}
+	if err := itA.Error(); err != nil {
+		utils.Fatalf("Failed to iterate %s: %v", ctx.Args().Get(0), err)
+	}
+	if err := itB.Error(); err != nil {
+		utils.Fatalf("Failed to iterate %s: %v", ctx.Args().Get(1), err)
+	}
+	fmt.Printf("\nIdentical: %d, only in A: %d, only in B: %d, mismatched: %d (took %v)\n", same, onlyA, onlyB, changed, time.Since(start))
+
+	if diffs := onlyA + onlyB + changed; diffs > 0 {
+		return fmt.Errorf("databases differ in %d keys", diffs)
+	}
+	return nil
+}
+
+func dbCompact(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	db, ok := chaindb.(*ethdb.LDBDatabase)
+	if !ok {
+		utils.Fatalf("Cannot compact in-memory chain database (%T), a data directory is required", chaindb)
+	}
+
+	// Compact either the requested prefix, or the whole keyspace split up by the
+	// first key byte, so progress can be reported along the way
+	var ranges []*util.Range
+	if prefix := parseKeyPrefix(ctx.String(dbRangeFlag.Name)); len(prefix) > 0 {
+		ranges = append(ranges, util.BytesPrefix(prefix))
+	} else {
+		for b := 0; b < 256; b++ {
+			rng := &util.Range{Start: []byte{byte(b)}}
+			if b < 255 {
+				rng.Limit = []byte{byte(b + 1)}
+			}
+			ranges = append(ranges, rng)
+		}
+		ranges[0].Start = nil
+	}
+	before, err := directorySize(db.Path())
+	if err != nil {
+		utils.Fatalf("Failed to measure database size: %v", err)
+	}
+	fmt.Printf("Compacting database %s (%v)...\n", db.Path(), before)
+
+	var (
+		start  = time.Now()
+		logged = start
+	)
+	for i, rng := range ranges {
+		if err := db.LDB().CompactRange(*rng); err != nil {
+			utils.Fatalf("Compaction failed: %v", err)
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Compacting database", "done", i+1, "total", len(ranges), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	after, err := directorySize(db.Path())
+	if err != nil {
+		utils.Fatalf("Failed to measure database size: %v", err)
+	}
+	fmt.Printf("Compaction done in %v: %v -> %v\n", time.Since(start), before, after)
+	return nil
+}
+
+// directorySize returns the total size of the files within a directory tree.
+func directorySize(path string) (common.StorageSize, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return common.StorageSize(size), err
+}
+
+func dbMigrateSchema(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	version := rawdb.ReadDatabaseVersion(db)
+	fmt.Printf("Database schema version: %d, supported: %d\n", version, core.BlockChainVersion)
+
+	pending, err := rawdb.PendingUpgrades(db, core.BlockChainVersion)
+	if err != nil {
+		utils.Fatalf("Cannot upgrade database: %v", err)
+	}
+	switch {
+	case version == 0:
+		fmt.Println("Database is unversioned, it will be stamped with the supported version")
+	case len(pending) == 0:
+		fmt.Println("Database schema is up to date")
+		return nil
+	default:
+		for _, upgrade := range pending {
+			fmt.Printf("  %d -> %d: %s\n", upgrade.From, upgrade.From+1, upgrade.Description)
+		}
+	}
+	if ctx.Bool(dbDryRunFlag.Name) {
+		return nil
+	}
+	start := time.Now()
+	if err := rawdb.UpgradeDatabase(db, core.BlockChainVersion); err != nil {
+		utils.Fatalf("Database upgrade failed: %v", err)
+	}
+	fmt.Printf("Database schema at version %d, took %v\n", rawdb.ReadDatabaseVersion(db), time.Since(start))
+	return nil
+}
@@ -183,6 +183,8 @@ func init() {
 		copydbCommand,
 		removedbCommand,
 		dumpCommand,
+		// See dbcmd.go:
+		dbCommand,
 		// See monitorcmd.go:
 		monitorCommand,
 		// See accountcmd.go:
@@ -65,9 +65,18 @@ const (
 	triesInMemory       = 128
 
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
-	BlockChainVersion = 3
+	BlockChainVersion = 4
 )
 
+func init() {
+	// Version 4 moved contract code into its own key namespace. Code stored by
+	// older versions under its bare hash is still found there, so the upgrade only
+	// stamps the new version, locking older binaries out of the database.
+	rawdb.RegisterUpgrade(3, "Move contract code into a separate key namespace", func(ethdb.Database) error {
+		return nil
+	})
+}
+
 // CacheConfig contains the configuration values for the trie caching/pruning
 // that's resident in a blockchain.
 type CacheConfig struct {
@@ -683,7 +692,11 @@ func (bc *BlockChain) GetUnclesInChain(block *types.Block, length int) []*types.
 // TrieNode retrieves a blob of data associated with a trie node (or code hash)
 // either from ephemeral in-memory cache, or from persistent storage.
 func (bc *BlockChain) TrieNode(hash common.Hash) ([]byte, error) {
-	return bc.stateCache.TrieDB().Node(hash)
+	if node, err := bc.stateCache.TrieDB().Node(hash); err == nil {
+		return node, nil
+	}
+	// Contract code is stored separately from the trie nodes
+	return bc.stateCache.ContractCode(common.Hash{}, hash)
 }
 
 // Stop stops the blockchain service. If any imports are currently in progress
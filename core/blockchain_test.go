@@ -17,6 +17,7 @@
 package core
 
 import (
+	"bytes"
 	"fmt"
 	"math/big"
 	"math/rand"
@@ -1373,6 +1374,27 @@ func TestLargeReorgTrieGC(t *testing.T) {
 	}
 }
 
+// Tests that databases of the previous schema version are upgraded to the
+// current one, with contract code stored by them remaining accessible.
+func TestBlockChainVersionUpgrade(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	rawdb.WriteDatabaseVersion(db, BlockChainVersion-1)
+
+	code := []byte{0x60, 0x00}
+	hash := crypto.Keccak256Hash(code)
+	db.Put(hash[:], code)
+
+	if err := rawdb.UpgradeDatabase(db, BlockChainVersion); err != nil {
+		t.Fatalf("failed to upgrade database: %v", err)
+	}
+	if version := rawdb.ReadDatabaseVersion(db); version != BlockChainVersion {
+		t.Fatalf("database version mismatch: have %d, want %d", version, BlockChainVersion)
+	}
+	if have := rawdb.ReadCode(db, hash); !bytes.Equal(have, code) {
+		t.Fatalf("legacy code mismatch: have %x, want %x", have, code)
+	}
+}
+
 // Benchmarks large blocks with value transfers to non-existing accounts
 func benchmarkLargeNumberOfValueToNonexisting(b *testing.B, numTxs, numBlocks int, recipientFn func(uint64) common.Address, dataFn func(uint64) []byte) {
 	var (
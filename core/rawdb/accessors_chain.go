@@ -50,6 +50,23 @@ func DeleteCanonicalHash(db DatabaseDeleter, number uint64) {
 	}
 }
 
+// ReadAllHashes retrieves all the hashes assigned to blocks at a certain height,
+// both canonical and reorged forks included.
+func ReadAllHashes(db DatabaseIteratee, number uint64) []common.Hash {
+	prefix := headerKeyPrefix(number)
+
+	it := db.NewIteratorWithPrefix(prefix)
+	defer it.Release()
+
+	var hashes []common.Hash
+	for it.Next() {
+		if key := it.Key(); len(key) == len(prefix)+common.HashLength {
+			hashes = append(hashes, common.BytesToHash(key[len(prefix):]))
+		}
+	}
+	return hashes
+}
+
 // ReadHeaderNumber returns the header number assigned to a hash.
 func ReadHeaderNumber(db DatabaseReader, hash common.Hash) *uint64 {
 	data, _ := db.Get(headerNumberKey(hash))
@@ -287,17 +304,26 @@ func ReadReceipts(db DatabaseReader, hash common.Hash, number uint64) types.Rece
 	if len(data) == 0 {
 		return nil
 	}
-	// Convert the receipts from their storage form to their internal representation
-	storageReceipts := []*types.ReceiptForStorage{}
-	if err := rlp.DecodeBytes(data, &storageReceipts); err != nil {
+	receipts, err := decodeReceipts(data)
+	if err != nil {
 		log.Error("Invalid receipt array RLP", "hash", hash, "err", err)
 		return nil
 	}
+	return receipts
+}
+
+// decodeReceipts converts the receipts of a block from their storage form to
+// their internal representation.
+func decodeReceipts(data []byte) (types.Receipts, error) {
+	storageReceipts := []*types.ReceiptForStorage{}
+	if err := rlp.DecodeBytes(data, &storageReceipts); err != nil {
+		return nil, err
+	}
 	receipts := make(types.Receipts, len(storageReceipts))
 	for i, receipt := range storageReceipts {
 		receipts[i] = (*types.Receipt)(receipt)
 	}
-	return receipts
+	return receipts, nil
 }
 
 // WriteReceipts stores all the transaction receipts belonging to a block.
@@ -229,6 +229,38 @@ func TestCanonicalMappingStorage(t *testing.T) {
 	}
 }
 
+// Tests that all the hashes at a given height can be retrieved, canonical or not.
+func TestReadAllHashes(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+
+	var hashes []common.Hash
+	for i := 0; i < 3; i++ {
+		header := &types.Header{Number: big.NewInt(42), Extra: []byte{byte(i)}}
+		WriteHeader(db, header)
+		WriteTd(db, header.Hash(), 42, big.NewInt(int64(i)))
+		hashes = append(hashes, header.Hash())
+	}
+	WriteCanonicalHash(db, hashes[1], 42)
+	WriteHeader(db, &types.Header{Number: big.NewInt(43)})
+
+	have := ReadAllHashes(db, 42)
+	if len(have) != len(hashes) {
+		t.Fatalf("hash count mismatch: have %d, want %d", len(have), len(hashes))
+	}
+	for _, hash := range hashes {
+		found := false
+		for _, h := range have {
+			found = found || h == hash
+		}
+		if !found {
+			t.Errorf("hash %x not retrieved", hash)
+		}
+	}
+	if have := ReadAllHashes(db, 41); len(have) != 0 {
+		t.Errorf("hashes retrieved for empty height: %x", have)
+	}
+}
+
 // Tests that head headers and head blocks can be assigned, individually.
 func TestHeadStorage(t *testing.T) {
 	db := ethdb.NewMemDatabase()
@@ -27,17 +27,21 @@ import (
 
 // ReadDatabaseVersion retrieves the version number of the database.
 func ReadDatabaseVersion(db DatabaseReader) int {
-	var version int
+	var version uint64
 
 	enc, _ := db.Get(databaseVerisionKey)
 	rlp.DecodeBytes(enc, &version)
 
-	return version
+	return int(version)
 }
 
 // WriteDatabaseVersion stores the version number of the database
 func WriteDatabaseVersion(db DatabaseWriter, version int) {
-	enc, _ := rlp.EncodeToBytes(version)
+	// RLP cannot encode signed integers, store the version as unsigned
+	enc, err := rlp.EncodeToBytes(uint64(version))
+	if err != nil {
+		log.Crit("Failed to RLP encode database version", "err", err)
+	}
 	if err := db.Put(databaseVerisionKey, enc); err != nil {
 		log.Crit("Failed to store the database version", "err", err)
 	}
@@ -87,3 +91,63 @@ func WritePreimages(db DatabaseWriter, preimages map[common.Hash][]byte) {
 	preimageCounter.Inc(int64(len(preimages)))
 	preimageHitCounter.Inc(int64(len(preimages)))
 }
+
+// ReadMigrationJournal retrieves the block ranges a migration stage has already
+// completed in the database.
+func ReadMigrationJournal(db DatabaseReader, stage string) []MigrationRange {
+	data, _ := db.Get(migrationJournalKey(stage))
+	if len(data) == 0 {
+		return nil
+	}
+	var ranges []MigrationRange
+	if err := rlp.DecodeBytes(data, &ranges); err != nil {
+		log.Error("Invalid migration journal RLP", "stage", stage, "err", err)
+		return nil
+	}
+	return ranges
+}
+
+// WriteMigrationJournal stores the block ranges a migration stage has completed.
+func WriteMigrationJournal(db DatabaseWriter, stage string, ranges []MigrationRange) {
+	data, err := rlp.EncodeToBytes(ranges)
+	if err != nil {
+		log.Crit("Failed to RLP encode migration journal", "err", err)
+	}
+	if err := db.Put(migrationJournalKey(stage), data); err != nil {
+		log.Crit("Failed to store migration journal", "err", err)
+	}
+}
+
+// ReadMigrationKeyRanges retrieves the key ranges a migration stage has already
+// completed in the database.
+func ReadMigrationKeyRanges(db DatabaseReader, stage string) []MigrationKeyRange {
+	data, _ := db.Get(migrationJournalKey(stage))
+	if len(data) == 0 {
+		return nil
+	}
+	var ranges []MigrationKeyRange
+	if err := rlp.DecodeBytes(data, &ranges); err != nil {
+		log.Error("Invalid migration key range journal RLP", "stage", stage, "err", err)
+		return nil
+	}
+	return ranges
+}
+
+// WriteMigrationKeyRanges stores the key ranges a migration stage has completed.
+func WriteMigrationKeyRanges(db DatabaseWriter, stage string, ranges []MigrationKeyRange) {
+	data, err := rlp.EncodeToBytes(ranges)
+	if err != nil {
+		log.Crit("Failed to RLP encode migration key range journal", "err", err)
+	}
+	if err := db.Put(migrationJournalKey(stage), data); err != nil {
+		log.Crit("Failed to store migration key range journal", "err", err)
+	}
+}
+
+// DeleteMigrationJournal removes the journal of a migration stage, forcing the
+// next run of the stage to redo everything.
+func DeleteMigrationJournal(db DatabaseDeleter, stage string) {
+	if err := db.Delete(migrationJournalKey(stage)); err != nil {
+		log.Crit("Failed to delete migration journal", "err", err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ReadCode retrieves the contract code of the provided code hash. Code written
+// before the introduction of the code prefix is stored under its bare hash
+// alongside the trie nodes, so that is checked as a fallback.
+func ReadCode(db DatabaseReader, hash common.Hash) []byte {
+	if data := ReadCodeWithPrefix(db, hash); len(data) > 0 {
+		return data
+	}
+	data, _ := db.Get(hash[:])
+	return data
+}
+
+// ReadCodeWithPrefix retrieves the contract code of the provided code hash, only
+// looking at the dedicated code namespace.
+func ReadCodeWithPrefix(db DatabaseReader, hash common.Hash) []byte {
+	data, _ := db.Get(codeKey(hash))
+	return data
+}
+
+// HasCode checks if the contract code corresponding to the provided code hash
+// is present in the database, either in the code namespace or under its bare
+// hash as stored by older versions.
+func HasCode(db DatabaseReader, hash common.Hash) bool {
+	if ok, _ := db.Has(codeKey(hash)); ok {
+		return true
+	}
+	ok, _ := db.Has(hash[:])
+	return ok
+}
+
+// WriteCode writes the provided contract code into the dedicated code namespace.
+func WriteCode(db DatabaseWriter, hash common.Hash, code []byte) {
+	if err := db.Put(codeKey(hash), code); err != nil {
+		log.Crit("Failed to store contract code", "err", err)
+	}
+}
+
+// DeleteCode deletes the specified contract code from the code namespace.
+func DeleteCode(db DatabaseDeleter, hash common.Hash) {
+	if err := db.Delete(codeKey(hash)); err != nil {
+		log.Crit("Failed to delete contract code", "err", err)
+	}
+}
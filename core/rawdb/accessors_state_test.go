@@ -0,0 +1,58 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests contract code storage and the fallback to legacy unprefixed code.
+func TestCodeStorage(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+
+	code := []byte{0x60, 0x00, 0x60, 0x00, 0xfd}
+	hash := crypto.Keccak256Hash(code)
+
+	if entry := ReadCode(db, hash); entry != nil {
+		t.Fatalf("Non existent code returned: %x", entry)
+	}
+	// Legacy code stored under the bare hash should still be readable
+	db.Put(hash[:], code)
+	if entry := ReadCode(db, hash); !bytes.Equal(entry, code) {
+		t.Fatalf("Legacy code mismatch: have %x, want %x", entry, code)
+	}
+	if entry := ReadCodeWithPrefix(db, hash); entry != nil {
+		t.Fatalf("Legacy code returned from the code namespace: %x", entry)
+	}
+	db.Delete(hash[:])
+
+	WriteCode(db, hash, code)
+	if entry := ReadCode(db, hash); !bytes.Equal(entry, code) {
+		t.Fatalf("Stored code mismatch: have %x, want %x", entry, code)
+	}
+	if has, _ := db.Has(hash[:]); has {
+		t.Fatalf("Code stored under its bare hash")
+	}
+	DeleteCode(db, hash)
+	if entry := ReadCode(db, hash); entry != nil {
+		t.Fatalf("Deleted code returned: %x", entry)
+	}
+}
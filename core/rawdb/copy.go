@@ -0,0 +1,227 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// TransformFunc is a hook applied to every key-value pair during a copy. It may
+// rewrite the key and/or value to be written into the destination (e.g. to add
+// or swap a prefix), or request the pair to be skipped altogether.
+type TransformFunc func(key, value []byte) (newKey, newValue []byte, skip bool)
+
+// CopyRange copies every key-value pair of src in the key range [start, end)
+// into dst. A nil start denotes the beginning of the keyspace, a nil end denotes
+// its end.
+//
+// The range is split into up to workers shards, each copied concurrently by its
+// own iterator into its own destination batch, flushed every ethdb.IdealBatchSize
+// bytes. Every flush journals the keys copied so far under the "copy" stage, so
+// an interrupted copy resumes where it left off, and ranges already copied by an
+// earlier run are skipped.
+func CopyRange(dst ethdb.Database, src DatabaseIteratee, start, end []byte, workers int) error {
+	return CopyRangeWithTransform(dst, src, start, end, workers, "copy", nil)
+}
+
+// CopyRangeWithTransform is a variant of CopyRange which passes every key-value
+// pair through transform before writing it into dst, allowing entries to be
+// re-keyed, rewritten or filtered out in the same pass as the copy. A nil
+// transform copies entries verbatim. The transform is invoked concurrently from
+// all workers.
+//
+// The journal cannot tell transforms apart, so the progress of the copy is kept
+// under the given stage, which must be unique to the transform. An empty stage
+// disables journaling altogether.
+func CopyRangeWithTransform(dst ethdb.Database, src DatabaseIteratee, start, end []byte, workers int, stage string, transform TransformFunc) error {
+	if end != nil && bytes.Compare(start, end) >= 0 {
+		return fmt.Errorf("invalid key range: %x >= %x", start, end)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	var journal *keyRangeJournal
+	if stage != "" {
+		journal = newKeyRangeJournal(dst, stage)
+	}
+	bounds := splitKeyRange(start, end, workers)
+
+	errc := make(chan error, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		go func(start, end []byte) {
+			errc <- copyShard(dst, src, start, end, journal, transform)
+		}(bounds[i], bounds[i+1])
+	}
+	var err error
+	for i := 0; i < len(bounds)-1; i++ {
+		if shardErr := <-errc; shardErr != nil && err == nil {
+			err = shardErr
+		}
+	}
+	return err
+}
+
+// Clone copies every key-value pair of src accepted by filter into dst, making
+// a full (nil filter) or partial copy of a database, e.g. everything but the
+// state trie, or only the entries under a given prefix. The copy is sharded over
+// workers concurrent iterators as in CopyRange, with progress logged periodically.
+// The filter is invoked concurrently from all workers.
+//
+// Progress is journaled under the given stage as with CopyRangeWithTransform, so
+// the stage must be unique to the filter, and an empty stage disables journaling.
+func Clone(dst ethdb.Database, src DatabaseIteratee, stage string, filter func(key []byte) bool, workers int) error {
+	var (
+		copied  uint64
+		skipped uint64
+		start   = time.Now()
+		done    = make(chan struct{})
+	)
+	go func() {
+		ticker := time.NewTicker(8 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				log.Info("Cloning database", "copied", atomic.LoadUint64(&copied), "skipped", atomic.LoadUint64(&skipped), "elapsed", common.PrettyDuration(time.Since(start)))
+			case <-done:
+				return
+			}
+		}
+	}()
+	err := CopyRangeWithTransform(dst, src, nil, nil, workers, stage, func(key, value []byte) ([]byte, []byte, bool) {
+		if filter != nil && !filter(key) {
+			atomic.AddUint64(&skipped, 1)
+			return nil, nil, true
+		}
+		atomic.AddUint64(&copied, 1)
+		return key, value, false
+	})
+	close(done)
+
+	if err != nil {
+		return err
+	}
+	log.Info("Cloned database", "copied", copied, "skipped", skipped, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// copyShard copies the key range [start, end) of src into dst using a single
+// iterator and batch, passing entries through the optional transform. If a
+// journal is given, keys already copied are skipped, and the keys copied so far
+// are committed to it along with every flush.
+func copyShard(dst ethdb.Database, src DatabaseIteratee, start, end []byte, journal *keyRangeJournal, transform TransformFunc) error {
+	pending := start // First key not yet committed to the journal
+	if journal != nil {
+		var ok bool
+		if pending, ok = journal.resume(start, end); !ok {
+			return nil
+		}
+	}
+	it := src.NewIterator()
+	defer it.Release()
+
+	batch := dst.NewBatch()
+	for ok := it.Seek(pending); ok; ok = it.Next() {
+		if end != nil && bytes.Compare(it.Key(), end) >= 0 {
+			break
+		}
+		key, value := it.Key(), it.Value()
+		if transform != nil {
+			var skip bool
+			if key, value, skip = transform(key, value); skip {
+				continue
+			}
+		}
+		if err := batch.Put(key, value); err != nil {
+			return err
+		}
+		migrationCopyMeter.Mark(1)
+
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if journal == nil {
+				if err := FlushMigrationBatch(batch); err != nil {
+					return err
+				}
+				continue
+			}
+			next := nextKey(it.Key())
+			if err := journal.commit(batch, pending, next); err != nil {
+				return err
+			}
+			pending = next
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if journal == nil {
+		return FlushMigrationBatch(batch)
+	}
+	return journal.commit(batch, pending, end)
+}
+
+// keyRangePrefixLength is the number of leading key bytes used to interpolate
+// shard boundaries.
+const keyRangePrefixLength = 8
+
+// splitKeyRange divides the key range [start, end) into at most n consecutive
+// shards, returning their boundaries: the first is start, the last is end. The
+// boundaries are interpolated linearly over the leading bytes of the keys, which
+// assumes a roughly uniform key distribution, as is the case for the hash-keyed
+// bulk of a chain database. Ranges too narrow to split yield a single shard.
+func splitKeyRange(start, end []byte, n int) [][]byte {
+	lo := new(big.Int).SetBytes(keyRangePrefix(start))
+	hi := new(big.Int).Lsh(big.NewInt(1), 8*keyRangePrefixLength)
+	if end != nil {
+		hi.SetBytes(keyRangePrefix(end))
+	}
+	width := new(big.Int).Sub(hi, lo)
+
+	bounds := [][]byte{start}
+	for i := 1; i < n; i++ {
+		bound := new(big.Int).Mul(width, big.NewInt(int64(i)))
+		bound.Div(bound, big.NewInt(int64(n)))
+		bound.Add(bound, lo)
+		if bound.Cmp(lo) <= 0 {
+			continue
+		}
+		key := math.PaddedBigBytes(bound, keyRangePrefixLength)
+		if bytes.Compare(key, bounds[len(bounds)-1]) <= 0 {
+			continue
+		}
+		bounds = append(bounds, key)
+	}
+	return append(bounds, end)
+}
+
+// keyRangePrefix returns the leading keyRangePrefixLength bytes of key, right
+// padded with zeroes if the key is shorter.
+func keyRangePrefix(key []byte) []byte {
+	prefix := make([]byte, keyRangePrefixLength)
+	copy(prefix, key)
+	return prefix
+}
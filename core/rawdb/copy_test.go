@@ -0,0 +1,214 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests that key ranges are split into ordered, non-overlapping shards covering
+// the entire requested range.
+func TestSplitKeyRange(t *testing.T) {
+	tests := []struct {
+		start, end []byte
+		n          int
+		shards     int
+	}{
+		{nil, nil, 1, 1},
+		{nil, nil, 16, 16},
+		{[]byte{0x10}, []byte{0x20}, 4, 4},
+		{[]byte("h"), []byte("i"), 3, 3},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 8, 1}, []byte{1, 2, 3, 4, 5, 6, 7, 8, 2}, 4, 1},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{1, 2, 3, 4, 5, 6, 7, 10}, 4, 2},
+	}
+	for i, tt := range tests {
+		bounds := splitKeyRange(tt.start, tt.end, tt.n)
+		if len(bounds)-1 != tt.shards {
+			t.Errorf("test %d: shard count mismatch: have %d, want %d", i, len(bounds)-1, tt.shards)
+		}
+		if !bytes.Equal(bounds[0], tt.start) || !bytes.Equal(bounds[len(bounds)-1], tt.end) {
+			t.Errorf("test %d: bounds %x do not span [%x, %x)", i, bounds, tt.start, tt.end)
+		}
+		for j := 1; j < len(bounds)-1; j++ {
+			if bytes.Compare(bounds[j-1], bounds[j]) >= 0 {
+				t.Errorf("test %d: bound %d (%x) not above previous (%x)", i, j, bounds[j], bounds[j-1])
+			}
+		}
+		if last := len(bounds) - 1; tt.end != nil && last > 0 && bytes.Compare(bounds[last-1], tt.end) >= 0 {
+			t.Errorf("test %d: bound %x not below end %x", i, bounds[last-1], tt.end)
+		}
+	}
+}
+
+// Tests that copying a key range transfers exactly the keys within it.
+func TestCopyRange(t *testing.T) {
+	for _, workers := range []int{1, 4, 32} {
+		src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+		for i := 0; i < 1000; i++ {
+			key := crypto.Keccak256([]byte{byte(i), byte(i >> 8)})
+			src.Put(key, []byte{byte(i)})
+		}
+		start, end := []byte{0x40}, []byte{0xc0}
+		if err := CopyRange(dst, src, start, end, workers); err != nil {
+			t.Fatalf("workers %d: failed to copy range: %v", workers, err)
+		}
+		for _, key := range src.Keys() {
+			want := bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) < 0
+			if has, _ := dst.Has(key); has != want {
+				t.Errorf("workers %d: key %x presence mismatch: have %v, want %v", workers, key, has, want)
+			}
+		}
+	}
+}
+
+// Tests that copies journal the key ranges they completed, skipping them when
+// re-run and resuming partially copied ones.
+func TestCopyRangeJournal(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	for i := 0; i < 1000; i++ {
+		src.Put(crypto.Keccak256([]byte{byte(i), byte(i >> 8)}), []byte{byte(i)})
+	}
+	if err := CopyRange(dst, src, nil, nil, 4); err != nil {
+		t.Fatalf("failed to copy range: %v", err)
+	}
+	if ranges := ReadMigrationKeyRanges(dst, "copy"); len(ranges) != 1 || len(ranges[0].Start) != 0 || len(ranges[0].End) != 0 {
+		t.Fatalf("journal mismatch: have %x, want the full keyspace", ranges)
+	}
+	// Entries added to the source after the copy must be skipped on a re-run
+	src.Put([]byte{0x01}, []byte{0x01})
+	if err := CopyRange(dst, src, nil, nil, 4); err != nil {
+		t.Fatalf("failed to re-run copy: %v", err)
+	}
+	if has, _ := dst.Has([]byte{0x01}); has {
+		t.Fatalf("journaled range copied again")
+	}
+	// A partially journaled copy must only copy the remainder
+	dst = ethdb.NewMemDatabase()
+	WriteMigrationKeyRanges(dst, "copy", []MigrationKeyRange{{Start: nil, End: []byte{0x80}}})
+
+	if err := CopyRange(dst, src, nil, nil, 3); err != nil {
+		t.Fatalf("failed to resume copy: %v", err)
+	}
+	for _, key := range src.Keys() {
+		if has, _ := dst.Has(key); has != (key[0] >= 0x80) {
+			t.Errorf("key %x presence mismatch: have %v, want %v", key, has, key[0] >= 0x80)
+		}
+	}
+	if ranges := ReadMigrationKeyRanges(dst, "copy"); len(ranges) != 1 || len(ranges[0].Start) != 0 || len(ranges[0].End) != 0 {
+		t.Fatalf("journal mismatch: have %x, want the full keyspace", ranges)
+	}
+}
+
+// Tests that journaled key ranges are merged and resumed from correctly.
+func TestMigrationKeyRanges(t *testing.T) {
+	tests := []struct {
+		add  []MigrationKeyRange
+		want string
+	}{
+		{[]MigrationKeyRange{{[]byte{5}, []byte{3}}}, "[]"},
+		{[]MigrationKeyRange{{[]byte{1}, []byte{3}}, {[]byte{3}, []byte{6}}}, "[{01 06}]"},
+		{[]MigrationKeyRange{{[]byte{4}, []byte{6}}, {nil, []byte{2}}}, "[{ 02} {04 06}]"},
+		{[]MigrationKeyRange{{[]byte{1}, nil}, {[]byte{3}, []byte{4}}}, "[{01 }]"},
+		{[]MigrationKeyRange{{[]byte{7}, nil}, {[]byte{1}, []byte{2}}, {[]byte{2}, []byte{7}}}, "[{01 }]"},
+	}
+	for i, tt := range tests {
+		journal := &keyRangeJournal{stage: "test"}
+		for _, r := range tt.add {
+			journal.add(r.Start, r.End)
+		}
+		if have := fmt.Sprintf("%x", journal.ranges); have != tt.want {
+			t.Errorf("test %d: ranges mismatch: have %s, want %s", i, have, tt.want)
+		}
+	}
+	journal := &keyRangeJournal{stage: "test"}
+	journal.add([]byte{1}, []byte{3})
+	journal.add([]byte{5}, nil)
+
+	if start, ok := journal.resume([]byte{0}, []byte{2}); !ok || !bytes.Equal(start, []byte{0}) {
+		t.Errorf("uncovered start: have %x/%v, want 00/true", start, ok)
+	}
+	if start, ok := journal.resume([]byte{2}, []byte{4}); !ok || !bytes.Equal(start, []byte{3}) {
+		t.Errorf("partially covered range: have %x/%v, want 03/true", start, ok)
+	}
+	if _, ok := journal.resume([]byte{1, 5}, []byte{3}); ok {
+		t.Errorf("covered range not reported done")
+	}
+	if _, ok := journal.resume([]byte{6}, nil); ok {
+		t.Errorf("covered open range not reported done")
+	}
+}
+
+// Tests that entries can be re-keyed and filtered while being copied.
+func TestCopyRangeWithTransform(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	for i := 0; i < 256; i++ {
+		src.Put([]byte{byte(i)}, []byte{byte(i)})
+	}
+	prefix := []byte("moved-")
+	transform := func(key, value []byte) ([]byte, []byte, bool) {
+		if key[0]%2 == 1 {
+			return nil, nil, true
+		}
+		return append(append([]byte{}, prefix...), key...), value, false
+	}
+	if err := CopyRangeWithTransform(dst, src, nil, nil, 8, "", transform); err != nil {
+		t.Fatalf("failed to copy range: %v", err)
+	}
+	if dst.Len() != 128 {
+		t.Fatalf("entry count mismatch: have %d, want %d", dst.Len(), 128)
+	}
+	for i := 0; i < 256; i += 2 {
+		if value, err := dst.Get(append(append([]byte{}, prefix...), byte(i))); err != nil || !bytes.Equal(value, []byte{byte(i)}) {
+			t.Errorf("entry %d mismatch: have %x, want %x", i, value, []byte{byte(i)})
+		}
+	}
+}
+
+// Tests that databases can be cloned in full or filtered by key.
+func TestClone(t *testing.T) {
+	src := ethdb.NewMemDatabase()
+	for i := 0; i < 500; i++ {
+		src.Put(crypto.Keccak256([]byte{byte(i), byte(i >> 8)}), []byte{byte(i)})
+		src.Put(append([]byte("h"), byte(i), byte(i>>8)), []byte{byte(i)})
+	}
+	// Clone everything, then everything but the hash-keyed entries
+	dst := ethdb.NewMemDatabase()
+	if err := Clone(dst, src, "", nil, 4); err != nil {
+		t.Fatalf("failed to clone database: %v", err)
+	}
+	if dst.Len() != src.Len() {
+		t.Fatalf("full clone entry count mismatch: have %d, want %d", dst.Len(), src.Len())
+	}
+	dst = ethdb.NewMemDatabase()
+	if err := Clone(dst, src, "", func(key []byte) bool { return len(key) != common.HashLength }, 4); err != nil {
+		t.Fatalf("failed to clone database: %v", err)
+	}
+	if dst.Len() != 500 {
+		t.Fatalf("filtered clone entry count mismatch: have %d, want %d", dst.Len(), 500)
+	}
+	for _, key := range dst.Keys() {
+		if !bytes.HasPrefix(key, []byte("h")) {
+			t.Errorf("filtered key %x cloned", key)
+		}
+	}
+}
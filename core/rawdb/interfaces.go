@@ -16,6 +16,8 @@
 
 package rawdb
 
+import "github.com/syndtr/goleveldb/leveldb/iterator"
+
 // DatabaseReader wraps the Has and Get method of a backing data store.
 type DatabaseReader interface {
 	Has(key []byte) (bool, error)
@@ -31,3 +33,16 @@ type DatabaseWriter interface {
 type DatabaseDeleter interface {
 	Delete(key []byte) error
 }
+
+// DatabaseIteratee wraps the NewIterator methods of a backing data store.
+type DatabaseIteratee interface {
+	NewIterator() iterator.Iterator
+	NewIteratorWithPrefix(prefix []byte) iterator.Iterator
+}
+
+// DatabaseIterableReader wraps the read and iteration methods of a backing data
+// store.
+type DatabaseIterableReader interface {
+	DatabaseReader
+	DatabaseIteratee
+}
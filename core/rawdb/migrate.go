@@ -0,0 +1,828 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var (
+	migrationBlockMeter     = metrics.NewRegisteredMeter("db/migration/blocks", nil)
+	migrationReceiptMeter   = metrics.NewRegisteredMeter("db/migration/receipts", nil)
+	migrationTxLookupMeter  = metrics.NewRegisteredMeter("db/migration/txlookups", nil)
+	migrationSideBlockMeter = metrics.NewRegisteredMeter("db/migration/sideblocks", nil)
+	migrationTrieNodeMeter  = metrics.NewRegisteredMeter("db/migration/trienodes", nil)
+	migrationPreimageMeter  = metrics.NewRegisteredMeter("db/migration/preimages", nil)
+	migrationCopyMeter      = metrics.NewRegisteredMeter("db/migration/copies", nil)
+	migrationWriteMeter     = metrics.NewRegisteredMeter("db/migration/write", nil)
+)
+
+// ProgressFunc is a callback invoked by long running migrations to report how
+// many units of work of a named stage are done out of a total. A zero total means
+// the amount of work is not known in advance.
+type ProgressFunc func(stage string, done, total uint64)
+
+// MigrateCanonicalBlocks copies the canonical blocks in the inclusive range
+// [from, to] from src into dst, together with everything needed to serve them:
+// the canonical number to hash mapping, header, body, total difficulty, receipts
+// and transaction lookup entries.
+//
+// Destination writes are accumulated into a single batch which is flushed every
+// ethdb.IdealBatchSize bytes, together with a journal of the migrated blocks.
+// Blocks already in the journal are skipped, so an interrupted migration can be
+// resumed by invoking it again.
+//
+// If verify is set, the header of every block is re-hashed and its transactions,
+// uncles and receipts are checked against the roots in it before being written,
+// so corrupted source data is rejected instead of being copied. Verified runs
+// keep a journal of their own, so blocks copied by an unverified run are copied
+// again with verification rather than skipped. If progress is non-nil, it is
+// invoked after every block.
+func MigrateCanonicalBlocks(dst ethdb.Database, src DatabaseReader, from, to uint64, verify bool, progress ProgressFunc) error {
+	if from > to {
+		return fmt.Errorf("invalid block range: #%d > #%d", from, to)
+	}
+	var (
+		batch   = dst.NewBatch()
+		journal = newMigrationJournal(dst, migrationStage("blocks", verify))
+		total   = to - from + 1 // Wraps to zero (unknown) for the full uint64 range
+		pending = from          // First block not yet committed to the journal
+		flushed bool            // Whether the last block was committed already
+		parent  common.Hash
+	)
+	for number := from; ; number++ {
+		hash := ReadCanonicalHash(src, number)
+		if hash == (common.Hash{}) {
+			return fmt.Errorf("missing canonical hash for block #%d", number)
+		}
+		if !journal.done(number) {
+			// Queue up the block's records and make sure it links up with the previous one
+			block, err := migrateCanonicalBlock(batch, src, hash, number, verify)
+			if err != nil {
+				return err
+			}
+			if number > from && block.ParentHash() != parent {
+				return fmt.Errorf("block #%d [%x…] not linked to canonical parent [%x…]", number, hash[:4], parent[:4])
+			}
+			migrationBlockMeter.Mark(1)
+			if batch.ValueSize() >= ethdb.IdealBatchSize {
+				if err := journal.commit(batch, pending, number); err != nil {
+					return err
+				}
+				pending, flushed = number+1, number == to
+			}
+		}
+		parent = hash
+
+		if progress != nil {
+			progress("blocks", number-from+1, total)
+		}
+		if number == to {
+			break
+		}
+	}
+	if flushed {
+		return nil
+	}
+	return journal.commit(batch, pending, to)
+}
+
+// MigrateCanonicalBlock copies a single canonical block from src into dst, with
+// the same records as MigrateCanonicalBlocks. All of them are written atomically
+// in a single batch. Single block migrations are not journaled. The verify flag
+// has the same meaning as for MigrateCanonicalBlocks.
+func MigrateCanonicalBlock(dst ethdb.Database, src DatabaseReader, number uint64, verify bool) error {
+	hash := ReadCanonicalHash(src, number)
+	if hash == (common.Hash{}) {
+		return fmt.Errorf("missing canonical hash for block #%d", number)
+	}
+	batch := dst.NewBatch()
+	if _, err := migrateCanonicalBlock(batch, src, hash, number, verify); err != nil {
+		return err
+	}
+	return FlushMigrationBatch(batch)
+}
+
+// migrateCanonicalBlock queues all the records of a canonical block from src into
+// the given destination batch, returning the block itself. If verify is set, the
+// block contents are checked against its header first.
+func migrateCanonicalBlock(batch ethdb.Batch, src DatabaseReader, hash common.Hash, number uint64, verify bool) (*types.Block, error) {
+	block := ReadBlock(src, hash, number)
+	if block == nil {
+		return nil, fmt.Errorf("missing block #%d [%x…]", number, hash[:4])
+	}
+	td := ReadTd(src, hash, number)
+	if td == nil {
+		return nil, fmt.Errorf("missing total difficulty for block #%d [%x…]", number, hash[:4])
+	}
+	receipts, err := readMigrationReceipts(src, hash, number)
+	if err != nil {
+		return nil, err
+	}
+	if verify {
+		if err := verifyBlockContents(hash, block.Header(), block.Body(), receipts); err != nil {
+			return nil, err
+		}
+	}
+	WriteCanonicalHash(batch, hash, number)
+	WriteBlock(batch, block)
+	WriteTd(batch, hash, number, td)
+	if receipts != nil {
+		WriteReceipts(batch, hash, number, receipts)
+	}
+	WriteTxLookupEntries(batch, block)
+
+	return block, nil
+}
+
+// MigrateReceipts copies the receipts of the canonical blocks in the inclusive
+// range [from, to] from src into dst, along with the canonical number to hash
+// mappings needed to locate them. It is meant for backfilling receipts into a
+// database that already holds the blocks themselves.
+//
+// Migrated blocks are journaled and skipped on subsequent runs, as with
+// MigrateCanonicalBlocks. If verify is set, the receipts of every block are
+// checked against the receipt root of its header in src before being written,
+// in a journal separate from unverified runs. If progress is non-nil, it is
+// invoked after every block.
+func MigrateReceipts(dst ethdb.Database, src DatabaseReader, from, to uint64, verify bool, progress ProgressFunc) error {
+	if from > to {
+		return fmt.Errorf("invalid block range: #%d > #%d", from, to)
+	}
+	var (
+		batch   = dst.NewBatch()
+		journal = newMigrationJournal(dst, migrationStage("receipts", verify))
+		total   = to - from + 1 // Wraps to zero (unknown) for the full uint64 range
+		pending = from          // First block not yet committed to the journal
+		flushed bool            // Whether the last block was committed already
+	)
+	for number := from; ; number++ {
+		if !journal.done(number) {
+			hash := ReadCanonicalHash(src, number)
+			if hash == (common.Hash{}) {
+				return fmt.Errorf("missing canonical hash for block #%d", number)
+			}
+			data, err := readOptional(src, blockReceiptsKey(number, hash))
+			if err != nil {
+				return fmt.Errorf("failed to read receipts for block #%d [%x…]: %v", number, hash[:4], err)
+			}
+			if len(data) == 0 {
+				return fmt.Errorf("missing receipts for block #%d [%x…]", number, hash[:4])
+			}
+			if verify {
+				header := ReadHeader(src, hash, number)
+				if header == nil {
+					return fmt.Errorf("missing header #%d [%x…]", number, hash[:4])
+				}
+				receipts, err := decodeReceipts(data)
+				if err != nil {
+					return fmt.Errorf("corrupt receipts for block #%d [%x…]: %v", number, hash[:4], err)
+				}
+				if err := verifyReceipts(hash, header, receipts); err != nil {
+					return err
+				}
+			}
+			WriteCanonicalHash(batch, hash, number)
+			if err := batch.Put(blockReceiptsKey(number, hash), data); err != nil {
+				return err
+			}
+			migrationReceiptMeter.Mark(1)
+			if batch.ValueSize() >= ethdb.IdealBatchSize {
+				if err := journal.commit(batch, pending, number); err != nil {
+					return err
+				}
+				pending, flushed = number+1, number == to
+			}
+		}
+		if progress != nil {
+			progress("receipts", number-from+1, total)
+		}
+		if number == to {
+			break
+		}
+	}
+	if flushed {
+		return nil
+	}
+	return journal.commit(batch, pending, to)
+}
+
+// MigrateTxLookupEntries (re)builds the transaction hash to block lookup entries
+// of the canonical blocks in the inclusive range [from, to] from the blocks in
+// src, writing them into dst. Only the lookup entries are written, so it can be
+// used to extend the transaction index of a database already holding the blocks.
+//
+// Indexed blocks are journaled and skipped on subsequent runs, as with
+// MigrateCanonicalBlocks. If progress is non-nil, it is invoked after every block.
+func MigrateTxLookupEntries(dst ethdb.Database, src DatabaseReader, from, to uint64, progress ProgressFunc) error {
+	if from > to {
+		return fmt.Errorf("invalid block range: #%d > #%d", from, to)
+	}
+	var (
+		batch   = dst.NewBatch()
+		journal = newMigrationJournal(dst, "tx lookups")
+		total   = to - from + 1 // Wraps to zero (unknown) for the full uint64 range
+		pending = from          // First block not yet committed to the journal
+		flushed bool            // Whether the last block was committed already
+	)
+	for number := from; ; number++ {
+		if !journal.done(number) {
+			hash := ReadCanonicalHash(src, number)
+			if hash == (common.Hash{}) {
+				return fmt.Errorf("missing canonical hash for block #%d", number)
+			}
+			block := ReadBlock(src, hash, number)
+			if block == nil {
+				return fmt.Errorf("missing block #%d [%x…]", number, hash[:4])
+			}
+			WriteTxLookupEntries(batch, block)
+			migrationTxLookupMeter.Mark(int64(len(block.Transactions())))
+
+			if batch.ValueSize() >= ethdb.IdealBatchSize {
+				if err := journal.commit(batch, pending, number); err != nil {
+					return err
+				}
+				pending, flushed = number+1, number == to
+			}
+		}
+		if progress != nil {
+			progress("tx lookups", number-from+1, total)
+		}
+		if number == to {
+			break
+		}
+	}
+	if flushed {
+		return nil
+	}
+	return journal.commit(batch, pending, to)
+}
+
+// MigrateChainSegment copies the chain segment ending in block toHash and starting
+// at its ancestor fromHash from src into dst, following parent hashes rather than
+// canonical numbers, so non-canonical (side chain) segments can be copied too.
+//
+// Headers, bodies, total difficulties and receipts are copied. Canonical number to
+// hash mappings and transaction lookup entries are not, as the segment need not be
+// canonical in either database. Migrated blocks are journaled per segment end and
+// skipped on subsequent runs, as with MigrateCanonicalBlocks. The verify flag has
+// the same meaning as for MigrateCanonicalBlocks. If progress is non-nil, it is
+// invoked after every block, walking backwards from toHash.
+func MigrateChainSegment(dst ethdb.Database, src DatabaseReader, fromHash, toHash common.Hash, verify bool, progress ProgressFunc) error {
+	from := ReadHeaderNumber(src, fromHash)
+	if from == nil {
+		return fmt.Errorf("unknown segment start [%x…]", fromHash[:4])
+	}
+	to := ReadHeaderNumber(src, toHash)
+	if to == nil {
+		return fmt.Errorf("unknown segment end [%x…]", toHash[:4])
+	}
+	if *from > *to {
+		return fmt.Errorf("invalid segment: #%d [%x…] > #%d [%x…]", *from, fromHash[:4], *to, toHash[:4])
+	}
+	var (
+		batch   = dst.NewBatch()
+		journal = newMigrationJournal(dst, migrationStage(fmt.Sprintf("segment %x", toHash), verify))
+		total   = *to - *from + 1
+		hash    = toHash
+		number  = *to
+		pending = *to // Last block not yet committed to the journal
+		flushed bool  // Whether the first block was committed already
+	)
+	for i := uint64(0); i < total; i++ {
+		// Ensure the walk ends exactly on the requested segment start
+		if number == *from && hash != fromHash {
+			return fmt.Errorf("block #%d [%x…] is not an ancestor of #%d [%x…]", *from, fromHash[:4], *to, toHash[:4])
+		}
+		header := ReadHeader(src, hash, number)
+		if header == nil {
+			return fmt.Errorf("missing header #%d [%x…]", number, hash[:4])
+		}
+		if !journal.done(number) {
+			if err := migrateSegmentBlock(batch, src, hash, header, verify); err != nil {
+				return err
+			}
+			migrationBlockMeter.Mark(1)
+
+			if batch.ValueSize() >= ethdb.IdealBatchSize {
+				if err := journal.commit(batch, number, pending); err != nil {
+					return err
+				}
+				pending, flushed = number-1, number == *from
+			}
+		}
+		if progress != nil {
+			progress("segment", i+1, total)
+		}
+		hash, number = header.ParentHash, number-1
+	}
+	if flushed {
+		return nil
+	}
+	return journal.commit(batch, *from, pending)
+}
+
+// migrateSegmentBlock queues the header, body, total difficulty and receipts of
+// a block of a chain segment from src into the given destination batch. If verify
+// is set, the block contents are checked against its header first.
+func migrateSegmentBlock(batch ethdb.Batch, src DatabaseReader, hash common.Hash, header *types.Header, verify bool) error {
+	number := header.Number.Uint64()
+
+	body := ReadBodyRLP(src, hash, number)
+	if len(body) == 0 {
+		return fmt.Errorf("missing body #%d [%x…]", number, hash[:4])
+	}
+	td := ReadTd(src, hash, number)
+	if td == nil {
+		return fmt.Errorf("missing total difficulty for block #%d [%x…]", number, hash[:4])
+	}
+	receipts, err := readOptional(src, blockReceiptsKey(number, hash))
+	if err != nil {
+		return fmt.Errorf("failed to read receipts for block #%d [%x…]: %v", number, hash[:4], err)
+	}
+	if verify {
+		decoded := ReadBody(src, hash, number)
+		if decoded == nil {
+			return fmt.Errorf("corrupt body #%d [%x…]", number, hash[:4])
+		}
+		var decodedReceipts types.Receipts
+		if receipts != nil {
+			if decodedReceipts, err = decodeReceipts(receipts); err != nil {
+				return fmt.Errorf("corrupt receipts for block #%d [%x…]: %v", number, hash[:4], err)
+			}
+		}
+		if err := verifyBlockContents(hash, header, decoded, decodedReceipts); err != nil {
+			return err
+		}
+	}
+	WriteHeader(batch, header)
+	WriteBodyRLP(batch, hash, number, body)
+	WriteTd(batch, hash, number, td)
+	if receipts != nil {
+		if err := batch.Put(blockReceiptsKey(number, hash), receipts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateSideChainBlocks copies every non-canonical block known to src at the
+// heights in the inclusive range [from, to] into dst, so that a database built
+// from the canonical chain can still follow a shallow reorg onto one of them.
+//
+// Side chain blocks are often incomplete, so each header is copied along with
+// whichever of its body, total difficulty and receipts are available. Canonical
+// mappings and transaction lookup entries are left untouched. Migrated heights
+// are journaled and skipped on subsequent runs, as with MigrateCanonicalBlocks.
+// If progress is non-nil, it is invoked after every height.
+func MigrateSideChainBlocks(dst ethdb.Database, src DatabaseIterableReader, from, to uint64, progress ProgressFunc) error {
+	if from > to {
+		return fmt.Errorf("invalid block range: #%d > #%d", from, to)
+	}
+	var (
+		batch   = dst.NewBatch()
+		journal = newMigrationJournal(dst, "side blocks")
+		total   = to - from + 1 // Wraps to zero (unknown) for the full uint64 range
+		pending = from          // First height not yet committed to the journal
+		flushed bool            // Whether the last height was committed already
+	)
+	for number := from; ; number++ {
+		if !journal.done(number) {
+			canonical := ReadCanonicalHash(src, number)
+			for _, hash := range ReadAllHashes(src, number) {
+				if hash == canonical {
+					continue
+				}
+				for _, key := range [][]byte{headerKey(number, hash), headerNumberKey(hash), headerTDKey(number, hash), blockBodyKey(number, hash), blockReceiptsKey(number, hash)} {
+					data, err := readOptional(src, key)
+					if err != nil {
+						return fmt.Errorf("failed to read side block #%d [%x…]: %v", number, hash[:4], err)
+					}
+					if data != nil {
+						if err := batch.Put(key, data); err != nil {
+							return err
+						}
+					}
+				}
+				migrationSideBlockMeter.Mark(1)
+			}
+			if batch.ValueSize() >= ethdb.IdealBatchSize {
+				if err := journal.commit(batch, pending, number); err != nil {
+					return err
+				}
+				pending, flushed = number+1, number == to
+			}
+		}
+		if progress != nil {
+			progress("side blocks", number-from+1, total)
+		}
+		if number == to {
+			break
+		}
+	}
+	if flushed {
+		return nil
+	}
+	return journal.commit(batch, pending, to)
+}
+
+// MetadataOptions selects the chain heads MigrateMetadata records in the
+// destination database. Zero hashes leave the corresponding head of the source
+// database in place.
+type MetadataOptions struct {
+	HeadHeader    common.Hash // Hash of the head header to record
+	HeadBlock     common.Hash // Hash of the head full block to record
+	HeadFastBlock common.Hash // Hash of the head fast sync block to record
+}
+
+// MigrateMetadata copies the database metadata from src into dst: the schema
+// version, the chain config stored under the genesis hash and the head header,
+// block and fast block markers. It is meant to be run after the chain data
+// itself has been migrated, as every recorded head has to be present in dst.
+//
+// Heads are copied verbatim from src unless overridden in opts, which may be nil,
+// so a database migrated up to an arbitrary block can be headed at that block
+// directly. All of the metadata is written atomically.
+func MigrateMetadata(dst ethdb.Database, src DatabaseReader, opts *MetadataOptions) error {
+	if opts == nil {
+		opts = new(MetadataOptions)
+	}
+	genesis := ReadCanonicalHash(src, 0)
+	if genesis == (common.Hash{}) {
+		return fmt.Errorf("missing genesis hash")
+	}
+	config, _ := src.Get(configKey(genesis))
+	if len(config) == 0 {
+		return fmt.Errorf("missing chain config for genesis [%x…]", genesis[:4])
+	}
+	heads := []struct {
+		name     string
+		hash     common.Hash
+		override common.Hash
+		write    func(DatabaseWriter, common.Hash)
+		full     bool
+	}{
+		{"header", ReadHeadHeaderHash(src), opts.HeadHeader, WriteHeadHeaderHash, false},
+		{"block", ReadHeadBlockHash(src), opts.HeadBlock, WriteHeadBlockHash, true},
+		{"fast block", ReadHeadFastBlockHash(src), opts.HeadFastBlock, WriteHeadFastBlockHash, true},
+	}
+	batch := dst.NewBatch()
+	for _, head := range heads {
+		hash := head.hash
+		if head.override != (common.Hash{}) {
+			hash = head.override
+		}
+		if hash == (common.Hash{}) {
+			return fmt.Errorf("missing head %s", head.name)
+		}
+		// Make sure the destination can actually serve the head
+		number := ReadHeaderNumber(dst, hash)
+		if number == nil || !HasHeader(dst, hash, *number) {
+			return fmt.Errorf("head %s [%x…] not in destination", head.name, hash[:4])
+		}
+		if head.full && !HasBody(dst, hash, *number) {
+			return fmt.Errorf("head %s #%d [%x…] missing body in destination", head.name, *number, hash[:4])
+		}
+		head.write(batch, hash)
+	}
+	if version := ReadDatabaseVersion(src); version != 0 {
+		WriteDatabaseVersion(batch, version)
+	}
+	if err := batch.Put(configKey(genesis), config); err != nil {
+		return err
+	}
+	return FlushMigrationBatch(batch)
+}
+
+// readOptional retrieves a record from src which may legitimately be missing,
+// returning nil without an error if it is. Unlike the Read* accessors, failed
+// reads are reported, so that e.g. a dropped connection to a remote source is not
+// mistaken for a missing record and migrated (and journaled) as such.
+func readOptional(src DatabaseReader, key []byte) ([]byte, error) {
+	has, err := src.Has(key)
+	if err != nil || !has {
+		return nil, err
+	}
+	return src.Get(key)
+}
+
+// readMigrationReceipts retrieves and decodes the receipts of a block from src,
+// returning nil if the block has none stored.
+func readMigrationReceipts(src DatabaseReader, hash common.Hash, number uint64) (types.Receipts, error) {
+	data, err := readOptional(src, blockReceiptsKey(number, hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read receipts for block #%d [%x…]: %v", number, hash[:4], err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	receipts, err := decodeReceipts(data)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt receipts for block #%d [%x…]: %v", number, hash[:4], err)
+	}
+	return receipts, nil
+}
+
+// migrationStage returns the name of the journal of a migration stage, keeping
+// verified runs apart so they don't skip blocks copied without verification.
+func migrationStage(stage string, verify bool) string {
+	if verify {
+		return "verified " + stage
+	}
+	return stage
+}
+
+// migrationJournal tracks the block ranges a migration stage has completed in a
+// destination database. The journal is keyed by stage only, so migrating a stage
+// from a different source requires deleting its journal first.
+type migrationJournal struct {
+	stage  string
+	ranges []MigrationRange // Sorted, non-overlapping and non-adjacent
+}
+
+// newMigrationJournal loads the journal of a migration stage from db.
+func newMigrationJournal(db DatabaseReader, stage string) *migrationJournal {
+	return &migrationJournal{
+		stage:  stage,
+		ranges: ReadMigrationJournal(db, stage),
+	}
+}
+
+// done returns whether the block with the given number was already migrated.
+func (j *migrationJournal) done(number uint64) bool {
+	for _, r := range j.ranges {
+		if r.From <= number && number <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// add marks the inclusive block range [from, to] as migrated.
+func (j *migrationJournal) add(from, to uint64) {
+	if from > to {
+		return
+	}
+	ranges := append(j.ranges, MigrationRange{From: from, To: to})
+	sort.Slice(ranges, func(a, b int) bool { return ranges[a].From < ranges[b].From })
+
+	merged := []MigrationRange{ranges[0]}
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if last.To == math.MaxUint64 || r.From <= last.To+1 {
+			if r.To > last.To {
+				last.To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	j.ranges = merged
+}
+
+// commit marks the inclusive block range [from, to] as migrated and flushes the
+// batch holding its data together with the updated journal, so that the two are
+// persisted atomically.
+func (j *migrationJournal) commit(batch ethdb.Batch, from, to uint64) error {
+	j.add(from, to)
+	WriteMigrationJournal(batch, j.stage, j.ranges)
+
+	return FlushMigrationBatch(batch)
+}
+
+// keyRangeJournal tracks the key ranges a migration stage has completed in a
+// destination database, for stages walking the keyspace rather than the chain.
+// It is safe for concurrent use by the workers of a sharded copy.
+type keyRangeJournal struct {
+	stage  string
+	ranges []MigrationKeyRange // Sorted, non-overlapping and non-adjacent
+	lock   sync.Mutex
+}
+
+// newKeyRangeJournal loads the key range journal of a migration stage from db.
+func newKeyRangeJournal(db DatabaseReader, stage string) *keyRangeJournal {
+	return &keyRangeJournal{
+		stage:  stage,
+		ranges: ReadMigrationKeyRanges(db, stage),
+	}
+}
+
+// resume returns the first key of the range [start, end) not yet migrated, or
+// false if the entire range was migrated already.
+func (j *keyRangeJournal) resume(start, end []byte) ([]byte, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	for _, r := range j.ranges {
+		if bytes.Compare(r.Start, start) <= 0 && (len(r.End) == 0 || bytes.Compare(start, r.End) < 0) {
+			if len(r.End) == 0 {
+				return nil, false
+			}
+			start = r.End
+		}
+	}
+	if end != nil && bytes.Compare(start, end) >= 0 {
+		return nil, false
+	}
+	return start, true
+}
+
+// add marks the key range [start, end) as migrated.
+func (j *keyRangeJournal) add(start, end []byte) {
+	if end != nil && bytes.Compare(start, end) >= 0 {
+		return
+	}
+	ranges := append(j.ranges, MigrationKeyRange{Start: common.CopyBytes(start), End: common.CopyBytes(end)})
+	sort.Slice(ranges, func(a, b int) bool { return bytes.Compare(ranges[a].Start, ranges[b].Start) < 0 })
+
+	merged := []MigrationKeyRange{ranges[0]}
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if len(last.End) == 0 || bytes.Compare(r.Start, last.End) <= 0 {
+			if len(last.End) != 0 && (len(r.End) == 0 || bytes.Compare(r.End, last.End) > 0) {
+				last.End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	j.ranges = merged
+}
+
+// commit marks the key range [start, end) as migrated and flushes the batch
+// holding its data together with the updated journal, so that the two are
+// persisted atomically.
+func (j *keyRangeJournal) commit(batch ethdb.Batch, start, end []byte) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.add(start, end)
+	WriteMigrationKeyRanges(batch, j.stage, j.ranges)
+
+	return FlushMigrationBatch(batch)
+}
+
+// nextKey returns the smallest key sorting after key.
+func nextKey(key []byte) []byte {
+	return append(common.CopyBytes(key), 0)
+}
+
+// prefixEnd returns the smallest key sorting after every key with the given
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := common.CopyBytes(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// FlushMigrationBatch flushes a batch of migrated data into its database and
+// resets it for further use. All migrations flush through it, so that their
+// write volume is metered in one place.
+func FlushMigrationBatch(batch ethdb.Batch) error {
+	migrationWriteMeter.Mark(int64(batch.ValueSize()))
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	batch.Reset()
+	return nil
+}
+
+// MigrateStateTrie copies every node of the hash-keyed trie rooted at root from
+// src into dst, verifying that each node hashes to the key it is stored under.
+// Only the trie itself is copied: the storage tries and contract code referenced
+// by the leaves of an account trie need to be migrated separately.
+//
+// The walk is sequential, so this is meant for small tries and verification
+// runs rather than for bulk copying a full chain state, which CopyRange does
+// with a journal. The migration is not journaled: an interrupted walk cannot be
+// resumed from the nodes already written, as their children may not have been,
+// so it is redone from the root when invoked again, rewriting identical nodes.
+// If progress is non-nil, it is invoked after every migrated node; the total
+// node count is unknown.
+func MigrateStateTrie(dst, src ethdb.Database, root common.Hash, progress ProgressFunc) error {
+	tr, err := trie.New(root, trie.NewDatabase(src))
+	if err != nil {
+		return err
+	}
+	var (
+		batch = dst.NewBatch()
+		nodes uint64
+	)
+
+	it := tr.NodeIterator(nil)
+	for it.Next(true) {
+		// Nodes embedded into their parents have no hash and no database entry
+		hash := it.Hash()
+		if hash == (common.Hash{}) {
+			continue
+		}
+		blob, err := src.Get(hash[:])
+		if err != nil {
+			return fmt.Errorf("missing trie node %x: %v", hash, err)
+		}
+		if have := crypto.Keccak256Hash(blob); have != hash {
+			return fmt.Errorf("trie node hash mismatch: have %x, want %x", have, hash)
+		}
+		if err := batch.Put(hash[:], blob); err != nil {
+			return err
+		}
+		migrationTrieNodeMeter.Mark(1)
+
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := FlushMigrationBatch(batch); err != nil {
+				return err
+			}
+		}
+		nodes++
+
+		if progress != nil {
+			progress("trie", nodes, 0)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return FlushMigrationBatch(batch)
+}
+
+// MigratePreimages copies every secure trie preimage (the hash to original key
+// mappings of account addresses and storage slots) from src into dst, verifying
+// that each preimage hashes to the key it is stored under.
+//
+// Every flush journals the preimages copied so far, so an interrupted migration
+// resumes after the last flushed preimage when invoked again. If progress is
+// non-nil, it is invoked after every migrated preimage; the total is unknown.
+func MigratePreimages(dst ethdb.Database, src DatabaseIteratee, progress ProgressFunc) error {
+	var (
+		journal = newKeyRangeJournal(dst, "preimages")
+		end     = prefixEnd(preimagePrefix)
+	)
+	pending, ok := journal.resume(preimagePrefix, end)
+	if !ok {
+		return nil
+	}
+	it := src.NewIterator()
+	defer it.Release()
+
+	var (
+		batch = dst.NewBatch()
+		count uint64
+	)
+	for ok := it.Seek(pending); ok && bytes.HasPrefix(it.Key(), preimagePrefix); ok = it.Next() {
+		hash := it.Key()[len(preimagePrefix):]
+		if have := crypto.Keccak256(it.Value()); !bytes.Equal(have, hash) {
+			return fmt.Errorf("preimage hash mismatch: have %x, want %x", have, hash)
+		}
+		if err := batch.Put(it.Key(), it.Value()); err != nil {
+			return err
+		}
+		migrationPreimageMeter.Mark(1)
+
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			next := nextKey(it.Key())
+			if err := journal.commit(batch, pending, next); err != nil {
+				return err
+			}
+			pending = next
+		}
+		count++
+
+		if progress != nil {
+			progress("preimages", count, 0)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return journal.commit(batch, pending, end)
+}
@@ -0,0 +1,709 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// makeMigrationChain writes a canonical chain of n blocks (each containing a
+// few transactions and matching receipts) into db and returns the blocks.
+func makeMigrationChain(db ethdb.Database, n int) []*types.Block {
+	var (
+		blocks []*types.Block
+		parent common.Hash
+	)
+	for i := 0; i < n; i++ {
+		var (
+			txs      []*types.Transaction
+			receipts []*types.Receipt
+		)
+		for j := 0; j < i%3; j++ {
+			tx := types.NewTransaction(uint64(i*10+j), common.Address{byte(i), byte(j)}, big.NewInt(int64(i)), 21000, big.NewInt(1), nil)
+			txs = append(txs, tx)
+			receipts = append(receipts, &types.Receipt{
+				Status:            types.ReceiptStatusSuccessful,
+				CumulativeGasUsed: uint64(21000 * (j + 1)),
+				Logs:              []*types.Log{},
+				TxHash:            tx.Hash(),
+				GasUsed:           21000,
+			})
+		}
+		header := &types.Header{
+			Number:     big.NewInt(int64(i)),
+			ParentHash: parent,
+			Difficulty: big.NewInt(131072),
+			Extra:      []byte("migration test"),
+		}
+		block := types.NewBlock(header, txs, nil, receipts)
+
+		WriteBlock(db, block)
+		WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		WriteTd(db, block.Hash(), block.NumberU64(), big.NewInt(int64(131072*(i+1))))
+		WriteReceipts(db, block.Hash(), block.NumberU64(), receipts)
+		WriteTxLookupEntries(db, block)
+
+		blocks = append(blocks, block)
+		parent = block.Hash()
+	}
+	return blocks
+}
+
+// Tests that a range of canonical blocks can be migrated between databases along
+// with all their auxiliary records.
+func TestMigrateCanonicalBlocks(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 10)
+
+	var calls uint64
+	progress := func(stage string, done, total uint64) {
+		calls++
+		if stage != "blocks" || done != calls || total != 6 {
+			t.Errorf("progress mismatch: have %s %d/%d, want %s %d/%d", stage, done, total, "blocks", calls, 6)
+		}
+	}
+	if err := MigrateCanonicalBlocks(dst, src, 2, 7, false, progress); err != nil {
+		t.Fatalf("failed to migrate blocks: %v", err)
+	}
+	if calls != 6 {
+		t.Fatalf("progress callback count mismatch: have %d, want %d", calls, 6)
+	}
+	for _, block := range blocks {
+		var (
+			hash   = block.Hash()
+			number = block.NumberU64()
+			want   = number >= 2 && number <= 7
+		)
+		if have := ReadCanonicalHash(dst, number) == hash; have != want {
+			t.Errorf("block #%d: canonical hash presence mismatch: have %v, want %v", number, have, want)
+		}
+		if have := ReadBlock(dst, hash, number) != nil; have != want {
+			t.Errorf("block #%d: block presence mismatch: have %v, want %v", number, have, want)
+		}
+		if have := ReadTd(dst, hash, number) != nil; have != want {
+			t.Errorf("block #%d: total difficulty presence mismatch: have %v, want %v", number, have, want)
+		}
+		if have := HasReceipts(dst, hash, number); have != want {
+			t.Errorf("block #%d: receipts presence mismatch: have %v, want %v", number, have, want)
+		}
+		for i, tx := range block.Transactions() {
+			if have, _, _, _ := ReadTransaction(dst, tx.Hash()); (have != nil) != want {
+				t.Errorf("block #%d: tx #%d lookup presence mismatch: have %v, want %v", number, i, have != nil, want)
+			}
+		}
+	}
+}
+
+// Tests that migrating a range with missing source data fails.
+func TestMigrateCanonicalBlocksMissing(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 5)
+
+	if err := MigrateCanonicalBlocks(dst, src, 3, 2, false, nil); err == nil {
+		t.Fatalf("inverted range migrated")
+	}
+	if err := MigrateCanonicalBlocks(dst, src, 0, 5, false, nil); err == nil {
+		t.Fatalf("range beyond the canonical head migrated")
+	}
+	DeleteBody(src, blocks[2].Hash(), 2)
+	if err := MigrateCanonicalBlocks(dst, src, 0, 4, false, nil); err == nil {
+		t.Fatalf("range with missing body migrated")
+	}
+}
+
+// Tests that verified migrations reject blocks whose contents do not match the
+// roots committed to by their headers.
+func TestMigrateCanonicalBlocksVerify(t *testing.T) {
+	src := ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 6)
+
+	if err := MigrateCanonicalBlocks(ethdb.NewMemDatabase(), src, 0, 5, true, nil); err != nil {
+		t.Fatalf("failed to migrate intact blocks: %v", err)
+	}
+	tests := []struct {
+		name    string
+		corrupt func(db ethdb.Database)
+	}{
+		{"header", func(db ethdb.Database) {
+			header := blocks[2].Header()
+			header.Extra = []byte("corrupted")
+			data, _ := rlp.EncodeToBytes(header)
+			db.Put(headerKey(2, blocks[2].Hash()), data)
+		}},
+		{"transactions", func(db ethdb.Database) {
+			WriteBody(db, blocks[2].Hash(), 2, blocks[1].Body())
+		}},
+		{"uncles", func(db ethdb.Database) {
+			body := blocks[2].Body()
+			body.Uncles = []*types.Header{blocks[0].Header()}
+			WriteBody(db, blocks[2].Hash(), 2, body)
+		}},
+		{"receipts", func(db ethdb.Database) {
+			WriteReceipts(db, blocks[2].Hash(), 2, ReadReceipts(db, blocks[1].Hash(), 1))
+		}},
+	}
+	for _, tt := range tests {
+		src := ethdb.NewMemDatabase()
+		makeMigrationChain(src, 6)
+		tt.corrupt(src)
+
+		// Verification must not be skipped for blocks migrated without it before
+		dst := ethdb.NewMemDatabase()
+		if err := MigrateCanonicalBlocks(dst, src, 0, 5, false, nil); err != nil {
+			t.Errorf("%s: unverified migration failed: %v", tt.name, err)
+		}
+		if err := MigrateCanonicalBlocks(dst, src, 0, 5, true, nil); err == nil {
+			t.Errorf("%s: corrupt block migrated", tt.name)
+		}
+		if err := MigrateChainSegment(ethdb.NewMemDatabase(), src, blocks[0].Hash(), blocks[5].Hash(), true, nil); err == nil {
+			t.Errorf("%s: corrupt segment migrated", tt.name)
+		}
+	}
+}
+
+// Tests that a single canonical block can be migrated atomically.
+func TestMigrateCanonicalBlock(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	makeMigrationChain(src, 4)
+
+	if err := MigrateCanonicalBlock(dst, src, 2, false); err != nil {
+		t.Fatalf("failed to migrate block: %v", err)
+	}
+	report, err := VerifyMigration(dst, src, 2, 2)
+	if err != nil {
+		t.Fatalf("failed to verify migration: %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("block migration incomplete: %v", report.Issues)
+	}
+	// A failing block must leave no partial records behind
+	hash := ReadCanonicalHash(src, 3)
+	DeleteTd(src, hash, 3)
+
+	before := dst.Len()
+	if err := MigrateCanonicalBlock(dst, src, 3, false); err == nil {
+		t.Fatalf("block with missing total difficulty migrated")
+	}
+	if dst.Len() != before {
+		t.Fatalf("failed migration left %d partial records", dst.Len()-before)
+	}
+}
+
+// Tests that receipts can be backfilled for a range of canonical blocks.
+func TestMigrateReceipts(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 6)
+
+	if err := MigrateReceipts(dst, src, 1, 4, false, nil); err != nil {
+		t.Fatalf("failed to migrate receipts: %v", err)
+	}
+	for _, block := range blocks {
+		var (
+			hash   = block.Hash()
+			number = block.NumberU64()
+			want   = number >= 1 && number <= 4
+		)
+		if have := HasReceipts(dst, hash, number); have != want {
+			t.Errorf("block #%d: receipts presence mismatch: have %v, want %v", number, have, want)
+		}
+		if have := ReadCanonicalHash(dst, number) == hash; have != want {
+			t.Errorf("block #%d: canonical hash presence mismatch: have %v, want %v", number, have, want)
+		}
+		if want && len(ReadReceipts(dst, hash, number)) != len(block.Transactions()) {
+			t.Errorf("block #%d: receipt count mismatch: have %d, want %d", number, len(ReadReceipts(dst, hash, number)), len(block.Transactions()))
+		}
+		if HasHeader(dst, hash, number) || HasBody(dst, hash, number) {
+			t.Errorf("block #%d: block data migrated along with receipts", number)
+		}
+	}
+	DeleteReceipts(src, blocks[5].Hash(), 5)
+	if err := MigrateReceipts(dst, src, 0, 5, false, nil); err == nil {
+		t.Fatalf("range with missing receipts migrated")
+	}
+}
+
+// Tests that verified receipt backfills reject receipts not matching the receipt
+// root of their header, even if they were already migrated without verification.
+func TestMigrateReceiptsVerify(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 6)
+
+	if err := MigrateReceipts(dst, src, 0, 5, true, nil); err != nil {
+		t.Fatalf("failed to migrate intact receipts: %v", err)
+	}
+	if ranges := ReadMigrationJournal(dst, "verified receipts"); len(ranges) != 1 || ranges[0] != (MigrationRange{0, 5}) {
+		t.Fatalf("journal mismatch: have %v, want %v", ranges, []MigrationRange{{0, 5}})
+	}
+	src, dst = ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	makeMigrationChain(src, 6)
+	WriteReceipts(src, blocks[2].Hash(), 2, ReadReceipts(src, blocks[1].Hash(), 1))
+
+	if err := MigrateReceipts(dst, src, 0, 5, false, nil); err != nil {
+		t.Fatalf("unverified migration failed: %v", err)
+	}
+	if err := MigrateReceipts(dst, src, 0, 5, true, nil); err == nil {
+		t.Fatalf("corrupt receipts migrated")
+	}
+	// Receipts can't be verified without the header committing to them
+	DeleteHeader(src, blocks[3].Hash(), 3)
+	if err := MigrateReceipts(ethdb.NewMemDatabase(), src, 3, 3, true, nil); err == nil {
+		t.Fatalf("receipts without header migrated")
+	}
+}
+
+// Tests that transaction lookup entries can be rebuilt for a block range.
+func TestMigrateTxLookupEntries(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 9)
+
+	if err := MigrateTxLookupEntries(dst, src, 3, 8, nil); err != nil {
+		t.Fatalf("failed to migrate tx lookup entries: %v", err)
+	}
+	entries := 0
+	for _, block := range blocks {
+		want := block.NumberU64() >= 3
+		for i, tx := range block.Transactions() {
+			hash, number, index := ReadTxLookupEntry(dst, tx.Hash())
+			if (hash != common.Hash{}) != want {
+				t.Errorf("block #%d: tx #%d lookup presence mismatch: have %v, want %v", block.NumberU64(), i, hash != common.Hash{}, want)
+			}
+			if want && (hash != block.Hash() || number != block.NumberU64() || index != uint64(i)) {
+				t.Errorf("block #%d: tx #%d lookup mismatch: have %x/%d/%d", block.NumberU64(), i, hash, number, index)
+			}
+			if want {
+				entries++
+			}
+		}
+	}
+	// Besides the lookup entries, only the migration journal should be written
+	if dst.Len() != entries+1 {
+		t.Errorf("entry count mismatch: have %d, want %d", dst.Len(), entries+1)
+	}
+}
+
+// makeMigrationFork writes a non-canonical chain of n blocks on top of parent into
+// db and returns the blocks.
+func makeMigrationFork(db ethdb.Database, parent *types.Block, n int) []*types.Block {
+	var fork []*types.Block
+	for i := 0; i < n; i++ {
+		block := types.NewBlock(&types.Header{
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			ParentHash: parent.Hash(),
+			Difficulty: big.NewInt(1),
+			Extra:      []byte("side chain"),
+		}, nil, nil, nil)
+		WriteBlock(db, block)
+		WriteTd(db, block.Hash(), block.NumberU64(), block.Number())
+		fork = append(fork, block)
+		parent = block
+	}
+	return fork
+}
+
+// Tests that database metadata can be migrated, with the recorded heads either
+// copied from the source or explicitly chosen.
+func TestMigrateMetadata(t *testing.T) {
+	src := ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 6)
+
+	WriteDatabaseVersion(src, 3)
+	WriteChainConfig(src, blocks[0].Hash(), params.TestChainConfig)
+	WriteHeadHeaderHash(src, blocks[5].Hash())
+	WriteHeadBlockHash(src, blocks[5].Hash())
+	WriteHeadFastBlockHash(src, blocks[5].Hash())
+
+	// Copying the heads verbatim requires the destination to hold them
+	dst := ethdb.NewMemDatabase()
+	if err := MigrateCanonicalBlocks(dst, src, 0, 3, false, nil); err != nil {
+		t.Fatalf("failed to migrate blocks: %v", err)
+	}
+	if err := MigrateMetadata(dst, src, nil); err == nil {
+		t.Fatalf("metadata migrated with heads missing from destination")
+	}
+	if ReadDatabaseVersion(dst) != 0 || ReadHeadBlockHash(dst) != (common.Hash{}) {
+		t.Fatalf("failed metadata migration left partial records")
+	}
+	// Explicitly chosen heads should be recorded instead of the source ones
+	opts := &MetadataOptions{
+		HeadHeader:    blocks[3].Hash(),
+		HeadBlock:     blocks[2].Hash(),
+		HeadFastBlock: blocks[3].Hash(),
+	}
+	if err := MigrateMetadata(dst, src, opts); err != nil {
+		t.Fatalf("failed to migrate metadata: %v", err)
+	}
+	if have := ReadHeadHeaderHash(dst); have != opts.HeadHeader {
+		t.Errorf("head header mismatch: have %x, want %x", have, opts.HeadHeader)
+	}
+	if have := ReadHeadBlockHash(dst); have != opts.HeadBlock {
+		t.Errorf("head block mismatch: have %x, want %x", have, opts.HeadBlock)
+	}
+	if have := ReadHeadFastBlockHash(dst); have != opts.HeadFastBlock {
+		t.Errorf("head fast block mismatch: have %x, want %x", have, opts.HeadFastBlock)
+	}
+	if have := ReadDatabaseVersion(dst); have != 3 {
+		t.Errorf("database version mismatch: have %d, want %d", have, 3)
+	}
+	if ReadChainConfig(dst, blocks[0].Hash()) == nil {
+		t.Errorf("chain config not migrated")
+	}
+	// Once the full chain is present, the source heads can be copied as is
+	if err := MigrateCanonicalBlocks(dst, src, 4, 5, false, nil); err != nil {
+		t.Fatalf("failed to migrate blocks: %v", err)
+	}
+	if err := MigrateMetadata(dst, src, nil); err != nil {
+		t.Fatalf("failed to migrate metadata: %v", err)
+	}
+	if have := ReadHeadBlockHash(dst); have != blocks[5].Hash() {
+		t.Errorf("head block mismatch: have %x, want %x", have, blocks[5].Hash())
+	}
+}
+
+// Tests that chain segments, including non-canonical ones, can be migrated by
+// following parent hashes.
+func TestMigrateChainSegment(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 8)
+
+	// Create a side chain forking off after block #3
+	fork := makeMigrationFork(src, blocks[3], 3)
+	if err := MigrateChainSegment(dst, src, blocks[2].Hash(), fork[2].Hash(), true, nil); err != nil {
+		t.Fatalf("failed to migrate side chain segment: %v", err)
+	}
+	for _, block := range append([]*types.Block{blocks[2], blocks[3]}, fork...) {
+		if ReadBlock(dst, block.Hash(), block.NumberU64()) == nil {
+			t.Errorf("block #%d [%x]: not migrated", block.NumberU64(), block.Hash())
+		}
+		if ReadTd(dst, block.Hash(), block.NumberU64()) == nil {
+			t.Errorf("block #%d [%x]: total difficulty not migrated", block.NumberU64(), block.Hash())
+		}
+		if ReadCanonicalHash(dst, block.NumberU64()) != (common.Hash{}) {
+			t.Errorf("block #%d [%x]: canonical mapping migrated", block.NumberU64(), block.Hash())
+		}
+	}
+	if !HasReceipts(dst, blocks[3].Hash(), 3) {
+		t.Errorf("canonical block receipts not migrated")
+	}
+	if ReadBlock(dst, blocks[4].Hash(), 4) != nil {
+		t.Errorf("block outside of the segment migrated")
+	}
+	stage := migrationStage(fmt.Sprintf("segment %x", fork[2].Hash()), true)
+	if ranges := ReadMigrationJournal(dst, stage); len(ranges) != 1 || ranges[0] != (MigrationRange{2, 6}) {
+		t.Errorf("journal mismatch: have %v, want %v", ranges, []MigrationRange{{2, 6}})
+	}
+	// Segments with unrelated endpoints must be rejected
+	if err := MigrateChainSegment(ethdb.NewMemDatabase(), src, blocks[4].Hash(), fork[2].Hash(), false, nil); err == nil {
+		t.Fatalf("segment from non-ancestor migrated")
+	}
+	if err := MigrateChainSegment(ethdb.NewMemDatabase(), src, fork[2].Hash(), blocks[2].Hash(), false, nil); err == nil {
+		t.Fatalf("inverted segment migrated")
+	}
+}
+
+// Tests that non-canonical blocks can be migrated alongside a canonical chain.
+func TestMigrateSideChainBlocks(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 8)
+	fork := makeMigrationFork(src, blocks[3], 3)
+
+	// Leave the last fork block without a body
+	DeleteBody(src, fork[2].Hash(), fork[2].NumberU64())
+
+	if err := MigrateSideChainBlocks(dst, src, 5, 7, nil); err != nil {
+		t.Fatalf("failed to migrate side chain blocks: %v", err)
+	}
+	if ReadBlock(dst, fork[0].Hash(), fork[0].NumberU64()) != nil {
+		t.Errorf("side chain block below the range migrated")
+	}
+	if ReadBlock(dst, fork[1].Hash(), fork[1].NumberU64()) == nil {
+		t.Errorf("side chain block not migrated")
+	}
+	if ReadHeader(dst, fork[2].Hash(), fork[2].NumberU64()) == nil || ReadTd(dst, fork[2].Hash(), fork[2].NumberU64()) == nil {
+		t.Errorf("bodiless side chain header not migrated")
+	}
+	if number := ReadHeaderNumber(dst, fork[1].Hash()); number == nil || *number != fork[1].NumberU64() {
+		t.Errorf("side chain hash to number mapping not migrated")
+	}
+	for _, block := range blocks {
+		if HasHeader(dst, block.Hash(), block.NumberU64()) {
+			t.Errorf("canonical block #%d migrated", block.NumberU64())
+		}
+	}
+	if ranges := ReadMigrationJournal(dst, "side blocks"); len(ranges) != 1 || ranges[0] != (MigrationRange{5, 7}) {
+		t.Errorf("journal mismatch: have %v, want %v", ranges, []MigrationRange{{5, 7}})
+	}
+}
+
+// Tests that block range migrations journal their progress in the destination
+// and skip already migrated blocks when re-run.
+func TestMigrationJournal(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 12)
+
+	if err := MigrateCanonicalBlocks(dst, src, 2, 7, false, nil); err != nil {
+		t.Fatalf("failed to migrate blocks: %v", err)
+	}
+	if ranges := ReadMigrationJournal(dst, "blocks"); len(ranges) != 1 || ranges[0] != (MigrationRange{2, 7}) {
+		t.Fatalf("journal mismatch: have %v, want %v", ranges, []MigrationRange{{2, 7}})
+	}
+	// Break an already migrated source block, re-running must skip it
+	DeleteBody(src, blocks[4].Hash(), 4)
+	if err := MigrateCanonicalBlocks(dst, src, 0, 11, false, nil); err != nil {
+		t.Fatalf("failed to resume migration: %v", err)
+	}
+	if ranges := ReadMigrationJournal(dst, "blocks"); len(ranges) != 1 || ranges[0] != (MigrationRange{0, 11}) {
+		t.Fatalf("journal mismatch: have %v, want %v", ranges, []MigrationRange{{0, 11}})
+	}
+	if ReadBlock(dst, blocks[4].Hash(), 4) == nil {
+		t.Fatalf("previously migrated block lost")
+	}
+	// Dropping the journal must force a full re-run
+	DeleteMigrationJournal(dst, "blocks")
+	if err := MigrateCanonicalBlocks(dst, src, 0, 11, false, nil); err == nil {
+		t.Fatalf("migration with broken source block succeeded")
+	}
+}
+
+// Tests that migrating up to the largest block number does not wrap around, and
+// in particular does not journal blocks that were never migrated.
+func TestMigrationJournalFullRange(t *testing.T) {
+	src := ethdb.NewMemDatabase()
+	makeMigrationChain(src, 3)
+
+	for _, db := range []ethdb.Database{ethdb.NewMemDatabase(), src} {
+		dst := ethdb.NewMemDatabase()
+		if err := MigrateCanonicalBlocks(dst, db, 0, math.MaxUint64, false, nil); err == nil {
+			t.Errorf("full range of blocks migrated")
+		}
+		if err := MigrateReceipts(dst, db, 0, math.MaxUint64, false, nil); err == nil {
+			t.Errorf("full range of receipts migrated")
+		}
+		if err := MigrateTxLookupEntries(dst, db, 0, math.MaxUint64, nil); err == nil {
+			t.Errorf("full range of tx lookups migrated")
+		}
+		for _, stage := range []string{"blocks", "receipts", "tx lookups"} {
+			if ranges := ReadMigrationJournal(dst, stage); len(ranges) != 0 {
+				t.Errorf("%s: failed migration journaled: %v", stage, ranges)
+			}
+		}
+	}
+	// Ranges ending at the largest block number must still be migrated
+	dst := ethdb.NewMemDatabase()
+	header := &types.Header{Number: new(big.Int).SetUint64(math.MaxUint64), Difficulty: big.NewInt(1)}
+	WriteHeader(src, header)
+	WriteCanonicalHash(src, header.Hash(), math.MaxUint64)
+	WriteReceipts(src, header.Hash(), math.MaxUint64, types.Receipts{})
+
+	if err := MigrateReceipts(dst, src, math.MaxUint64, math.MaxUint64, false, nil); err != nil {
+		t.Fatalf("failed to migrate last block receipts: %v", err)
+	}
+	if ranges := ReadMigrationJournal(dst, "receipts"); len(ranges) != 1 || ranges[0] != (MigrationRange{math.MaxUint64, math.MaxUint64}) {
+		t.Fatalf("journal mismatch: have %v, want %v", ranges, []MigrationRange{{math.MaxUint64, math.MaxUint64}})
+	}
+}
+
+// failingReader is a database reader failing every read of a single key, as a
+// remote database does when the connection drops.
+type failingReader struct {
+	DatabaseReader
+	key []byte
+}
+
+func (r *failingReader) Has(key []byte) (bool, error) {
+	if bytes.Equal(key, r.key) {
+		return false, errors.New("connection lost")
+	}
+	return r.DatabaseReader.Has(key)
+}
+
+func (r *failingReader) Get(key []byte) ([]byte, error) {
+	if bytes.Equal(key, r.key) {
+		return nil, errors.New("connection lost")
+	}
+	return r.DatabaseReader.Get(key)
+}
+
+// Tests that failing source reads abort migrations instead of being taken for
+// missing records, which would be skipped and journaled as migrated.
+func TestMigrationFailingReads(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 6)
+	faulty := &failingReader{src, blockReceiptsKey(4, blocks[4].Hash())}
+
+	if err := MigrateCanonicalBlocks(dst, faulty, 0, 5, false, nil); err == nil {
+		t.Errorf("block migration with failing receipt read succeeded")
+	}
+	if err := MigrateReceipts(dst, faulty, 0, 5, false, nil); err == nil {
+		t.Errorf("receipt migration with failing receipt read succeeded")
+	}
+	if err := MigrateChainSegment(dst, faulty, blocks[0].Hash(), blocks[5].Hash(), false, nil); err == nil {
+		t.Errorf("segment migration with failing receipt read succeeded")
+	}
+	for _, stage := range []string{"blocks", "receipts"} {
+		if ranges := ReadMigrationJournal(dst, stage); len(ranges) != 0 {
+			t.Errorf("%s: failed migration journaled: %v", stage, ranges)
+		}
+	}
+	// Once the source recovers, the migration must pick up the receipts
+	if err := MigrateCanonicalBlocks(dst, src, 0, 5, false, nil); err != nil {
+		t.Fatalf("failed to migrate blocks: %v", err)
+	}
+	if !HasReceipts(dst, blocks[4].Hash(), 4) {
+		t.Fatalf("receipts missing after recovered migration")
+	}
+}
+
+// Tests that journaled block ranges are merged correctly.
+func TestMigrationJournalRanges(t *testing.T) {
+	tests := []struct {
+		add  []MigrationRange
+		want []MigrationRange
+	}{
+		{[]MigrationRange{{5, 3}}, nil},
+		{[]MigrationRange{{1, 3}, {4, 6}}, []MigrationRange{{1, 6}}},
+		{[]MigrationRange{{4, 6}, {1, 2}}, []MigrationRange{{1, 2}, {4, 6}}},
+		{[]MigrationRange{{1, 10}, {3, 4}}, []MigrationRange{{1, 10}}},
+		{[]MigrationRange{{7, 9}, {1, 2}, {3, 6}}, []MigrationRange{{1, 9}}},
+		{[]MigrationRange{{0, math.MaxUint64}, {5, 6}}, []MigrationRange{{0, math.MaxUint64}}},
+	}
+	for i, tt := range tests {
+		journal := &migrationJournal{stage: "test"}
+		for _, r := range tt.add {
+			journal.add(r.From, r.To)
+		}
+		if fmt.Sprint(journal.ranges) != fmt.Sprint(tt.want) {
+			t.Errorf("test %d: ranges mismatch: have %v, want %v", i, journal.ranges, tt.want)
+		}
+	}
+}
+
+// makeMigrationTrie writes a trie with n random entries into db and returns its
+// root hash.
+func makeMigrationTrie(db ethdb.Database, n int) common.Hash {
+	triedb := trie.NewDatabase(db)
+	tr, _ := trie.New(common.Hash{}, triedb)
+	for i := 0; i < n; i++ {
+		key := crypto.Keccak256([]byte{byte(i), byte(i >> 8)})
+		tr.Update(key, bytes.Repeat([]byte{byte(i)}, 1+i%40))
+	}
+	root, _ := tr.Commit(nil)
+	triedb.Commit(root, false)
+	return root
+}
+
+// Tests that a trie can be migrated node by node with hash verification.
+func TestMigrateStateTrie(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	root := makeMigrationTrie(src, 500)
+
+	var nodes uint64
+	progress := func(stage string, done, total uint64) {
+		nodes = done
+	}
+	if err := MigrateStateTrie(dst, src, root, progress); err != nil {
+		t.Fatalf("failed to migrate trie: %v", err)
+	}
+	if nodes != uint64(src.Len()) {
+		t.Errorf("progress node count mismatch: have %d, want %d", nodes, src.Len())
+	}
+	for _, key := range src.Keys() {
+		have, _ := dst.Get(key)
+		want, _ := src.Get(key)
+		if !bytes.Equal(have, want) {
+			t.Errorf("node %x mismatch: have %x, want %x", key, have, want)
+		}
+	}
+	if dst.Len() != src.Len() {
+		t.Errorf("node count mismatch: have %d, want %d", dst.Len(), src.Len())
+	}
+}
+
+// Tests that corrupt or missing trie nodes are detected during migration.
+func TestMigrateStateTrieCorrupt(t *testing.T) {
+	src := ethdb.NewMemDatabase()
+	root := makeMigrationTrie(src, 500)
+
+	// Replace a node with a valid, but differently hashed one
+	var victim []byte
+	for _, key := range src.Keys() {
+		if !bytes.Equal(key, root[:]) {
+			victim = key
+			break
+		}
+	}
+	other, _ := src.Get(root[:])
+	src.Put(victim, other)
+
+	if err := MigrateStateTrie(ethdb.NewMemDatabase(), src, root, nil); err == nil {
+		t.Fatalf("corrupt trie migrated")
+	}
+	// Drop the node entirely
+	src.Delete(victim)
+	if err := MigrateStateTrie(ethdb.NewMemDatabase(), src, root, nil); err == nil {
+		t.Fatalf("incomplete trie migrated")
+	}
+}
+
+// Tests that secure trie preimages are migrated, and only them.
+func TestMigratePreimages(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	makeMigrationChain(src, 3)
+
+	preimages := make(map[common.Hash][]byte)
+	for i := 0; i < 100; i++ {
+		preimage := common.Address{byte(i)}.Bytes()
+		preimages[crypto.Keccak256Hash(preimage)] = preimage
+	}
+	WritePreimages(src, preimages)
+
+	if err := MigratePreimages(dst, src, nil); err != nil {
+		t.Fatalf("failed to migrate preimages: %v", err)
+	}
+	if dst.Len() != len(preimages)+1 { // Preimages and the journal
+		t.Fatalf("entry count mismatch: have %d, want %d", dst.Len(), len(preimages)+1)
+	}
+	want := []MigrationKeyRange{{preimagePrefix, prefixEnd(preimagePrefix)}}
+	if ranges := ReadMigrationKeyRanges(dst, "preimages"); fmt.Sprint(ranges) != fmt.Sprint(want) {
+		t.Fatalf("journal mismatch: have %x, want %x", ranges, want)
+	}
+	for hash, preimage := range preimages {
+		if have := ReadPreimage(dst, hash); !bytes.Equal(have, preimage) {
+			t.Errorf("preimage %x mismatch: have %x, want %x", hash, have, preimage)
+		}
+	}
+	// Corrupt a preimage and ensure it's detected
+	for hash := range preimages {
+		WritePreimages(src, map[common.Hash][]byte{hash: {0xde, 0xad}})
+		break
+	}
+	if err := MigratePreimages(ethdb.NewMemDatabase(), src, nil); err == nil {
+		t.Fatalf("corrupt preimage migrated")
+	}
+}
@@ -18,7 +18,9 @@
 package rawdb
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/metrics"
@@ -52,10 +54,13 @@ var (
 
 	txLookupPrefix  = []byte("l") // txLookupPrefix + hash -> transaction/receipt lookup metadata
 	bloomBitsPrefix = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
+	codePrefix      = []byte("c") // codePrefix + code hash -> account code
 
 	preimagePrefix = []byte("secure-key-")      // preimagePrefix + hash -> preimage
 	configPrefix   = []byte("ethereum-config-") // config prefix for the db
 
+	migrationJournalPrefix = []byte("migration-journal-") // migrationJournalPrefix + stage -> completed block or key ranges
+
 	// Chain index prefixes (use `i` + single byte to avoid mixing data types).
 	BloomBitsIndexPrefix = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
 
@@ -71,6 +76,19 @@ type TxLookupEntry struct {
 	Index      uint64
 }
 
+// MigrationRange is an inclusive range of block numbers a migration stage has
+// completed in a destination database.
+type MigrationRange struct {
+	From, To uint64
+}
+
+// MigrationKeyRange is a half-open range [Start, End) of keys a migration stage
+// has completed in a destination database. An empty End denotes the end of the
+// keyspace.
+type MigrationKeyRange struct {
+	Start, End []byte
+}
+
 // encodeBlockNumber encodes a block number as big endian uint64
 func encodeBlockNumber(number uint64) []byte {
 	enc := make([]byte, 8)
@@ -78,9 +96,14 @@ func encodeBlockNumber(number uint64) []byte {
 	return enc
 }
 
+// headerKeyPrefix = headerPrefix + num (uint64 big endian)
+func headerKeyPrefix(number uint64) []byte {
+	return append(headerPrefix, encodeBlockNumber(number)...)
+}
+
 // headerKey = headerPrefix + num (uint64 big endian) + hash
 func headerKey(number uint64, hash common.Hash) []byte {
-	return append(append(headerPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+	return append(headerKeyPrefix(number), hash.Bytes()...)
 }
 
 // headerTDKey = headerPrefix + num (uint64 big endian) + hash + headerTDSuffix
@@ -123,6 +146,20 @@ func bloomBitsKey(bit uint, section uint64, hash common.Hash) []byte {
 	return key
 }
 
+// codeKey = codePrefix + hash
+func codeKey(hash common.Hash) []byte {
+	return append(codePrefix, hash.Bytes()...)
+}
+
+// IsCodeKey reports whether the given byte slice is the key of contract code,
+// if so it returns the raw code hash as well.
+func IsCodeKey(key []byte) (bool, []byte) {
+	if bytes.HasPrefix(key, codePrefix) && len(key) == common.HashLength+len(codePrefix) {
+		return true, key[len(codePrefix):]
+	}
+	return false, nil
+}
+
 // preimageKey = preimagePrefix + hash
 func preimageKey(hash common.Hash) []byte {
 	return append(preimagePrefix, hash.Bytes()...)
@@ -132,3 +169,54 @@ func preimageKey(hash common.Hash) []byte {
 func configKey(hash common.Hash) []byte {
 	return append(configPrefix, hash.Bytes()...)
 }
+
+// migrationJournalKey = migrationJournalPrefix + stage
+func migrationJournalKey(stage string) []byte {
+	return append(migrationJournalPrefix, stage...)
+}
+
+// DescribeKey returns a human readable description of a database key based on
+// the schema above, e.g. "header #1234 [0a1b2c3d…]". Keys which do not match any
+// known layout are described as unknown.
+func DescribeKey(key []byte) string {
+	for _, meta := range [][]byte{databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey} {
+		if bytes.Equal(key, meta) {
+			return fmt.Sprintf("metadata %s", meta)
+		}
+	}
+	number := func(key []byte) uint64 { return binary.BigEndian.Uint64(key[1:9]) }
+
+	switch {
+	case bytes.HasPrefix(key, preimagePrefix) && len(key) == len(preimagePrefix)+common.HashLength:
+		return fmt.Sprintf("preimage [%x…]", key[len(preimagePrefix):len(preimagePrefix)+4])
+	case bytes.HasPrefix(key, configPrefix) && len(key) == len(configPrefix)+common.HashLength:
+		return fmt.Sprintf("chain config [%x…]", key[len(configPrefix):len(configPrefix)+4])
+	case bytes.HasPrefix(key, migrationJournalPrefix):
+		return fmt.Sprintf("migration journal %q", key[len(migrationJournalPrefix):])
+	case bytes.HasPrefix(key, BloomBitsIndexPrefix):
+		return fmt.Sprintf("bloombits index %x", key[len(BloomBitsIndexPrefix):])
+
+	case bytes.HasPrefix(key, headerPrefix) && len(key) == 1+8+len(headerHashSuffix) && bytes.HasSuffix(key, headerHashSuffix):
+		return fmt.Sprintf("canonical hash #%d", number(key))
+	case bytes.HasPrefix(key, headerPrefix) && len(key) == 1+8+common.HashLength:
+		return fmt.Sprintf("header #%d [%x…]", number(key), key[9:13])
+	case bytes.HasPrefix(key, headerPrefix) && len(key) == 1+8+common.HashLength+len(headerTDSuffix) && bytes.HasSuffix(key, headerTDSuffix):
+		return fmt.Sprintf("total difficulty #%d [%x…]", number(key), key[9:13])
+	case bytes.HasPrefix(key, headerNumberPrefix) && len(key) == 1+common.HashLength:
+		return fmt.Sprintf("hash to number [%x…]", key[1:5])
+	case bytes.HasPrefix(key, blockBodyPrefix) && len(key) == 1+8+common.HashLength:
+		return fmt.Sprintf("body #%d [%x…]", number(key), key[9:13])
+	case bytes.HasPrefix(key, blockReceiptsPrefix) && len(key) == 1+8+common.HashLength:
+		return fmt.Sprintf("receipts #%d [%x…]", number(key), key[9:13])
+	case bytes.HasPrefix(key, txLookupPrefix) && len(key) == 1+common.HashLength:
+		return fmt.Sprintf("tx lookup [%x…]", key[1:5])
+	case bytes.HasPrefix(key, codePrefix) && len(key) == 1+common.HashLength:
+		return fmt.Sprintf("code [%x…]", key[1:5])
+	case bytes.HasPrefix(key, bloomBitsPrefix) && len(key) == 1+2+8+common.HashLength:
+		return fmt.Sprintf("bloom bits %d section %d [%x…]", binary.BigEndian.Uint16(key[1:3]), binary.BigEndian.Uint64(key[3:11]), key[11:15])
+
+	case len(key) == common.HashLength:
+		return fmt.Sprintf("trie node or code [%x…]", key[:4])
+	}
+	return fmt.Sprintf("unknown %x", key)
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Tests that database keys are described according to their schema.
+func TestDescribeKey(t *testing.T) {
+	hash := common.HexToHash("0x0a1b2c3d00000000000000000000000000000000000000000000000000000000")
+
+	tests := []struct {
+		key  []byte
+		want string
+	}{
+		{headHeaderKey, "metadata LastHeader"},
+		{headerHashKey(1234), "canonical hash #1234"},
+		{headerKey(1234, hash), "header #1234 [0a1b2c3d…]"},
+		{headerTDKey(1234, hash), "total difficulty #1234 [0a1b2c3d…]"},
+		{headerNumberKey(hash), "hash to number [0a1b2c3d…]"},
+		{blockBodyKey(7, hash), "body #7 [0a1b2c3d…]"},
+		{blockReceiptsKey(7, hash), "receipts #7 [0a1b2c3d…]"},
+		{txLookupKey(hash), "tx lookup [0a1b2c3d…]"},
+		{bloomBitsKey(3, 5, hash), "bloom bits 3 section 5 [0a1b2c3d…]"},
+		{codeKey(hash), "code [0a1b2c3d…]"},
+		{preimageKey(hash), "preimage [0a1b2c3d…]"},
+		{configKey(hash), "chain config [0a1b2c3d…]"},
+		{migrationJournalKey("blocks"), `migration journal "blocks"`},
+		{hash.Bytes(), "trie node or code [0a1b2c3d…]"},
+		{[]byte("hx"), "unknown 6878"},
+	}
+	for i, tt := range tests {
+		if have := DescribeKey(tt.key); have != tt.want {
+			t.Errorf("test %d: description mismatch: have %q, want %q", i, have, tt.want)
+		}
+	}
+}
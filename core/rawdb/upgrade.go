@@ -0,0 +1,129 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// UpgradeFunc converts the contents of a database from one schema version to
+// the next one. It must be safe to rerun on a database it was already (partially)
+// applied to, since a crash may prevent the version bump from being persisted.
+type UpgradeFunc func(db ethdb.Database) error
+
+// DatabaseUpgrade is a registered schema upgrade step.
+type DatabaseUpgrade struct {
+	From        int    // Schema version the upgrade converts from (to From+1)
+	Description string // Human readable summary of the conversion
+	fn          UpgradeFunc
+}
+
+// upgradeRegistry is a set of database schema upgrades, keyed by the version
+// they upgrade from.
+type upgradeRegistry struct {
+	upgrades map[int]*DatabaseUpgrade
+	lock     sync.RWMutex
+}
+
+// upgrades is the registry of the schema upgrades known to this codebase.
+var upgrades = newUpgradeRegistry()
+
+func newUpgradeRegistry() *upgradeRegistry {
+	return &upgradeRegistry{upgrades: make(map[int]*DatabaseUpgrade)}
+}
+
+// RegisterUpgrade adds fn as the upgrade converting a database of the given
+// schema version into version+1, with a short description of what it does. It
+// panics if an upgrade is already registered for the version, as two competing
+// conversions would leave the database in an undefined state.
+func RegisterUpgrade(version int, description string, fn UpgradeFunc) {
+	upgrades.register(version, description, fn)
+}
+
+// PendingUpgrades returns the upgrades UpgradeDatabase would run to bring the
+// database up to the target schema version, in order, without running them. The
+// same errors are returned as by UpgradeDatabase.
+func PendingUpgrades(db DatabaseReader, target int) ([]*DatabaseUpgrade, error) {
+	return upgrades.pending(db, target)
+}
+
+// UpgradeDatabase brings the schema of the database up to the target version
+// by running the registered upgrades in order, persisting the version after each
+// step so an interrupted upgrade resumes where it left off. A database without
+// a version is considered fresh and simply gets stamped with the target.
+//
+// An error is returned if the database is newer than the target or if an upgrade
+// needed along the way is not registered.
+func UpgradeDatabase(db ethdb.Database, target int) error {
+	return upgrades.upgrade(db, target)
+}
+
+func (r *upgradeRegistry) register(version int, description string, fn UpgradeFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.upgrades[version]; ok {
+		panic(fmt.Sprintf("duplicate database upgrade from version %d", version))
+	}
+	r.upgrades[version] = &DatabaseUpgrade{From: version, Description: description, fn: fn}
+}
+
+func (r *upgradeRegistry) pending(db DatabaseReader, target int) ([]*DatabaseUpgrade, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	version := ReadDatabaseVersion(db)
+	switch {
+	case version == 0:
+		return nil, nil
+	case version > target:
+		return nil, fmt.Errorf("database version %d newer than supported %d", version, target)
+	}
+	var pending []*DatabaseUpgrade
+	for v := version; v < target; v++ {
+		upgrade := r.upgrades[v]
+		if upgrade == nil {
+			return nil, fmt.Errorf("no database upgrade from version %d to %d", v, v+1)
+		}
+		pending = append(pending, upgrade)
+	}
+	return pending, nil
+}
+
+func (r *upgradeRegistry) upgrade(db ethdb.Database, target int) error {
+	// Make sure the entire upgrade path is known before touching anything
+	pending, err := r.pending(db, target)
+	if err != nil {
+		return err
+	}
+	if ReadDatabaseVersion(db) == 0 {
+		WriteDatabaseVersion(db, target)
+		return nil
+	}
+	for _, upgrade := range pending {
+		log.Info("Upgrading database schema", "from", upgrade.From, "to", upgrade.From+1, "upgrade", upgrade.Description)
+		if err := upgrade.fn(db); err != nil {
+			return fmt.Errorf("database upgrade from version %d failed: %v", upgrade.From, err)
+		}
+		WriteDatabaseVersion(db, upgrade.From+1)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests that database upgrades are run in order and the version is persisted
+// after each step.
+func TestUpgradeDatabase(t *testing.T) {
+	var (
+		registry = newUpgradeRegistry()
+		applied  []int
+	)
+	for v := 1; v < 4; v++ {
+		v := v
+		registry.register(v, fmt.Sprintf("upgrade %d", v), func(db ethdb.Database) error {
+			if have := ReadDatabaseVersion(db); have != v {
+				t.Errorf("upgrade %d: database version mismatch: have %d, want %d", v, have, v)
+			}
+			applied = append(applied, v)
+			return nil
+		})
+	}
+	// Fresh databases should be stamped without running anything
+	db := ethdb.NewMemDatabase()
+	if err := registry.upgrade(db, 4); err != nil {
+		t.Fatalf("failed to initialise fresh database: %v", err)
+	}
+	if version := ReadDatabaseVersion(db); version != 4 {
+		t.Fatalf("fresh database version mismatch: have %d, want %d", version, 4)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("upgrades run on fresh database: %v", applied)
+	}
+	// Old databases should go through all the steps in order
+	db = ethdb.NewMemDatabase()
+	WriteDatabaseVersion(db, 1)
+
+	pending, err := registry.pending(db, 4)
+	if err != nil {
+		t.Fatalf("failed to list pending upgrades: %v", err)
+	}
+	if len(pending) != 3 || pending[0].From != 1 || pending[2].Description != "upgrade 3" {
+		t.Fatalf("pending upgrades mismatch: have %v", pending)
+	}
+	if len(applied) != 0 || ReadDatabaseVersion(db) != 1 {
+		t.Fatalf("listing pending upgrades modified the database")
+	}
+	if err := registry.upgrade(db, 4); err != nil {
+		t.Fatalf("failed to upgrade database: %v", err)
+	}
+	if version := ReadDatabaseVersion(db); version != 4 {
+		t.Fatalf("upgraded database version mismatch: have %d, want %d", version, 4)
+	}
+	if len(applied) != 3 || applied[0] != 1 || applied[1] != 2 || applied[2] != 3 {
+		t.Fatalf("upgrade order mismatch: have %v, want [1 2 3]", applied)
+	}
+	// Up to date databases should be left alone, newer ones rejected
+	applied = nil
+	if err := registry.upgrade(db, 4); err != nil || len(applied) != 0 {
+		t.Fatalf("up to date database: err %v, upgrades %v", err, applied)
+	}
+	if err := registry.upgrade(db, 3); err == nil {
+		t.Fatalf("newer database accepted")
+	}
+}
+
+// Tests that a failing or missing upgrade stops the process, keeping the last
+// successfully reached version.
+func TestUpgradeDatabaseFailure(t *testing.T) {
+	registry := newUpgradeRegistry()
+	registry.register(1, "noop", func(ethdb.Database) error { return nil })
+	registry.register(2, "failing", func(ethdb.Database) error { return errors.New("boom") })
+
+	db := ethdb.NewMemDatabase()
+	WriteDatabaseVersion(db, 1)
+	if err := registry.upgrade(db, 3); err == nil {
+		t.Fatalf("failing upgrade succeeded")
+	}
+	if version := ReadDatabaseVersion(db); version != 2 {
+		t.Fatalf("version mismatch after failure: have %d, want %d", version, 2)
+	}
+	// Gaps in the upgrade path must be detected before anything is run
+	if err := registry.upgrade(db, 4); err == nil {
+		t.Fatalf("missing upgrade not detected")
+	}
+	if version := ReadDatabaseVersion(db); version != 2 {
+		t.Fatalf("version mismatch after missing upgrade: have %d, want %d", version, 2)
+	}
+}
+
+// Tests that registering two upgrades from the same version panics.
+func TestRegisterDuplicateUpgrade(t *testing.T) {
+	registry := newUpgradeRegistry()
+	registry.register(1, "noop", func(ethdb.Database) error { return nil })
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("duplicate upgrade registered")
+		}
+	}()
+	registry.register(1, "noop", func(ethdb.Database) error { return nil })
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// MigrationIssue describes a single record of a migrated block which is either
+// absent from the destination database, or differs from its source counterpart.
+type MigrationIssue struct {
+	Number  uint64      // Number of the block the record belongs to
+	Hash    common.Hash // Hash of the block the record belongs to
+	Record  string      // Kind of record (e.g. "header", "receipts", "tx lookup")
+	Key     []byte      // Database key of the record
+	Missing bool        // Whether the record is absent, or present with a different value
+}
+
+// String implements fmt.Stringer.
+func (issue MigrationIssue) String() string {
+	state := "mismatched"
+	if issue.Missing {
+		state = "missing"
+	}
+	return fmt.Sprintf("block #%d [%x…]: %s %s (key %x)", issue.Number, issue.Hash[:4], state, issue.Record, issue.Key)
+}
+
+// MigrationReport is the result of verifying a migrated block range.
+type MigrationReport struct {
+	From, To uint64           // Inclusive block range verified
+	Records  uint64           // Number of individual records checked
+	Issues   []MigrationIssue // Records missing or mismatched in the destination
+}
+
+// OK returns whether every checked record was found intact in the destination.
+func (report *MigrationReport) OK() bool {
+	return len(report.Issues) == 0
+}
+
+// VerifyMigration re-reads every record that a migration of the canonical blocks
+// in the inclusive range [from, to] is expected to produce from both src and dst,
+// and reports any which are missing or different in dst: canonical hashes, hash
+// to number mappings, headers, total difficulties, bodies, receipts and tx lookup
+// entries. Receipts are only expected for blocks which have them in src.
+//
+// An error is returned only if the source itself lacks the canonical chain over
+// the range, in which case there is nothing to verify against.
+func VerifyMigration(dst, src DatabaseReader, from, to uint64) (*MigrationReport, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid block range: #%d > #%d", from, to)
+	}
+	report := &MigrationReport{From: from, To: to}
+
+	for number := from; ; number++ {
+		hash := ReadCanonicalHash(src, number)
+		if hash == (common.Hash{}) {
+			return nil, fmt.Errorf("missing canonical hash for block #%d", number)
+		}
+		body := ReadBody(src, hash, number)
+		if body == nil {
+			return nil, fmt.Errorf("missing body for block #%d [%x…]", number, hash[:4])
+		}
+		check := func(record string, key []byte) {
+			report.Records++
+
+			want, _ := src.Get(key)
+			have, _ := dst.Get(key)
+			switch {
+			case len(have) == 0 && len(want) != 0:
+				report.Issues = append(report.Issues, MigrationIssue{Number: number, Hash: hash, Record: record, Key: key, Missing: true})
+			case !bytes.Equal(have, want):
+				report.Issues = append(report.Issues, MigrationIssue{Number: number, Hash: hash, Record: record, Key: key})
+			}
+		}
+		check("canonical hash", headerHashKey(number))
+		check("hash to number mapping", headerNumberKey(hash))
+		check("header", headerKey(number, hash))
+		check("total difficulty", headerTDKey(number, hash))
+		check("body", blockBodyKey(number, hash))
+		if HasReceipts(src, hash, number) {
+			check("receipts", blockReceiptsKey(number, hash))
+		}
+		for _, tx := range body.Transactions {
+			check("tx lookup", txLookupKey(tx.Hash()))
+		}
+		if number == to {
+			break
+		}
+	}
+	return report, nil
+}
+
+// verifyBlockContents checks that a header hashes to the hash it is stored under,
+// and that the transactions and uncles of its body, and its receipts if non-nil,
+// hash to the roots committed to by the header. It catches headers, bodies and
+// receipts corrupted or mixed up in a database, which would otherwise only be
+// detected once a node tried to serve or reprocess them.
+func verifyBlockContents(hash common.Hash, header *types.Header, body *types.Body, receipts types.Receipts) error {
+	number := header.Number.Uint64()
+
+	if have := header.Hash(); have != hash {
+		return fmt.Errorf("header hash mismatch for block #%d [%x…]: have %x", number, hash[:4], have)
+	}
+	if root := types.DeriveSha(types.Transactions(body.Transactions)); root != header.TxHash {
+		return fmt.Errorf("transaction root mismatch for block #%d [%x…]: have %x, want %x", number, hash[:4], root, header.TxHash)
+	}
+	if uncles := types.CalcUncleHash(body.Uncles); uncles != header.UncleHash {
+		return fmt.Errorf("uncle hash mismatch for block #%d [%x…]: have %x, want %x", number, hash[:4], uncles, header.UncleHash)
+	}
+	if receipts != nil {
+		return verifyReceipts(hash, header, receipts)
+	}
+	return nil
+}
+
+// verifyReceipts checks that a header hashes to the hash it is stored under, and
+// that the receipts of its block hash to the receipt root committed to by it.
+func verifyReceipts(hash common.Hash, header *types.Header, receipts types.Receipts) error {
+	number := header.Number.Uint64()
+
+	if have := header.Hash(); have != hash {
+		return fmt.Errorf("header hash mismatch for block #%d [%x…]: have %x", number, hash[:4], have)
+	}
+	if root := types.DeriveSha(receipts); root != header.ReceiptHash {
+		return fmt.Errorf("receipt root mismatch for block #%d [%x…]: have %x, want %x", number, hash[:4], root, header.ReceiptHash)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests that migration verification detects missing and mismatched records.
+func TestVerifyMigration(t *testing.T) {
+	src, dst := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	blocks := makeMigrationChain(src, 8)
+
+	if err := MigrateCanonicalBlocks(dst, src, 0, 7, false, nil); err != nil {
+		t.Fatalf("failed to migrate blocks: %v", err)
+	}
+	report, err := VerifyMigration(dst, src, 0, 7)
+	if err != nil {
+		t.Fatalf("failed to verify migration: %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("intact migration reported issues: %v", report.Issues)
+	}
+	// Damage the destination and ensure every problem is reported
+	DeleteReceipts(dst, blocks[3].Hash(), 3)
+	WriteTd(dst, blocks[5].Hash(), 5, big.NewInt(1))
+
+	report, err = VerifyMigration(dst, src, 0, 7)
+	if err != nil {
+		t.Fatalf("failed to verify migration: %v", err)
+	}
+	if len(report.Issues) != 2 {
+		t.Fatalf("issue count mismatch: have %d, want %d: %v", len(report.Issues), 2, report.Issues)
+	}
+	if issue := report.Issues[0]; issue.Number != 3 || issue.Record != "receipts" || !issue.Missing {
+		t.Errorf("first issue mismatch: have %v", issue)
+	}
+	if issue := report.Issues[1]; issue.Number != 5 || issue.Record != "total difficulty" || issue.Missing {
+		t.Errorf("second issue mismatch: have %v", issue)
+	}
+	// Verifying beyond the source chain must fail
+	if _, err := VerifyMigration(dst, src, 0, 8); err == nil {
+		t.Fatalf("verification beyond the source chain succeeded")
+	}
+}
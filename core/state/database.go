@@ -17,13 +17,16 @@
 package state
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/trie"
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/golang-lru/simplelru"
 )
 
 // Trie cache generation limit after which to evict trie nodes from memory.
@@ -36,6 +39,9 @@ const (
 
 	// Number of codehash->size associations to keep.
 	codeSizeCacheSize = 100000
+
+	// Cache size granted for caching clean code.
+	codeCacheSize = 64 * 1024 * 1024
 )
 
 // Database wraps access to tries and contract code.
@@ -86,6 +92,7 @@ func NewDatabaseWithCache(db ethdb.Database, cache int) Database {
 	return &cachingDB{
 		db:            trie.NewDatabaseWithCache(db, cache),
 		codeSizeCache: csc,
+		codeCache:     newCodeCache(codeCacheSize),
 	}
 }
 
@@ -94,6 +101,7 @@ type cachingDB struct {
 	mu            sync.Mutex
 	pastTries     []*trie.SecureTrie
 	codeSizeCache *lru.Cache
+	codeCache     *codeCache
 }
 
 // OpenTrie opens the main account trie.
@@ -144,11 +152,16 @@ func (db *cachingDB) CopyTrie(t Trie) Trie {
 
 // ContractCode retrieves a particular contract's code.
 func (db *cachingDB) ContractCode(addrHash, codeHash common.Hash) ([]byte, error) {
-	code, err := db.db.Node(codeHash)
-	if err == nil {
-		db.codeSizeCache.Add(codeHash, len(code))
+	if code := db.codeCache.get(codeHash); code != nil {
+		return code, nil
+	}
+	code := rawdb.ReadCode(db.db.DiskDB(), codeHash)
+	if len(code) == 0 {
+		return nil, errors.New("not found")
 	}
-	return code, err
+	db.codeCache.add(codeHash, code)
+	db.codeSizeCache.Add(codeHash, len(code))
+	return code, nil
 }
 
 // ContractCodeSize retrieves a particular contracts code's size.
@@ -182,3 +195,52 @@ func (m cachedTrie) Commit(onleaf trie.LeafCallback) (common.Hash, error) {
 func (m cachedTrie) Prove(key []byte, fromLevel uint, proofDb ethdb.Putter) error {
 	return m.SecureTrie.Prove(key, fromLevel, proofDb)
 }
+
+// codeCache is an LRU cache of contract code keyed by code hash, bounded by the
+// total size of the cached code rather than the number of entries.
+type codeCache struct {
+	lru   *simplelru.LRU
+	size  int // Total size of the cached code
+	limit int // Maximum total size of the cached code
+	lock  sync.Mutex
+}
+
+// newCodeCache creates a code cache holding at most limit bytes of code.
+func newCodeCache(limit int) *codeCache {
+	c := &codeCache{limit: limit}
+
+	// Every entry is at least a byte long, so the item limit never kicks in
+	c.lru, _ = simplelru.NewLRU(limit, func(_, code interface{}) {
+		c.size -= len(code.([]byte))
+	})
+	return c
+}
+
+// get retrieves the code of the given hash if cached.
+func (c *codeCache) get(hash common.Hash) []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if code, ok := c.lru.Get(hash); ok {
+		return code.([]byte)
+	}
+	return nil
+}
+
+// add inserts the code of the given hash into the cache, evicting the least
+// recently used entries until the cache fits into its limit again.
+func (c *codeCache) add(hash common.Hash, code []byte) {
+	if len(code) > c.limit {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.lru.Contains(hash) {
+		return
+	}
+	c.lru.Add(hash, code)
+	for c.size += len(code); c.size > c.limit; {
+		c.lru.RemoveOldest()
+	}
+}
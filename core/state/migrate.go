@@ -0,0 +1,95 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// migrationCodeMeter counts the contract code blobs copied by MigrateContractCode.
+var migrationCodeMeter = metrics.NewRegisteredMeter("db/migration/code", nil)
+
+// MigrateContractCode copies the bytecode of every contract referenced by the
+// account trie rooted at root from src into dst. Contract code is not part of
+// the trie itself, so migrating the trie nodes alone leaves it behind.
+//
+// Every code blob is verified to hash to the code hash of the account referencing
+// it before being written; shared code is copied only once. Code is content
+// addressed, so instead of keeping a journal, blobs already present in the code
+// namespace of dst are taken as migrated and skipped, letting an interrupted
+// migration be resumed by invoking it again. The account trie itself is walked
+// in full on every run. If progress is non-nil, it is invoked after every code
+// blob; the total is unknown.
+func MigrateContractCode(dst, src ethdb.Database, root common.Hash, progress rawdb.ProgressFunc) error {
+	tr, err := trie.New(root, trie.NewDatabase(src))
+	if err != nil {
+		return err
+	}
+	var (
+		batch = dst.NewBatch()
+		done  = make(map[common.Hash]struct{})
+	)
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		var account Account
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return fmt.Errorf("invalid account %x: %v", it.Key, err)
+		}
+		if bytes.Equal(account.CodeHash, emptyCodeHash) {
+			continue
+		}
+		hash := common.BytesToHash(account.CodeHash)
+		if _, ok := done[hash]; ok {
+			continue
+		}
+		if len(rawdb.ReadCodeWithPrefix(dst, hash)) == 0 {
+			code := rawdb.ReadCode(src, hash)
+			if len(code) == 0 {
+				return fmt.Errorf("missing code %x of account %x", hash, it.Key)
+			}
+			if have := crypto.Keccak256Hash(code); have != hash {
+				return fmt.Errorf("code hash mismatch for account %x: have %x, want %x", it.Key, have, hash)
+			}
+			rawdb.WriteCode(batch, hash, code)
+			migrationCodeMeter.Mark(1)
+
+			if batch.ValueSize() >= ethdb.IdealBatchSize {
+				if err := rawdb.FlushMigrationBatch(batch); err != nil {
+					return err
+				}
+			}
+		}
+		done[hash] = struct{}{}
+
+		if progress != nil {
+			progress("code", uint64(len(done)), 0)
+		}
+	}
+	if it.Err != nil {
+		return it.Err
+	}
+	return rawdb.FlushMigrationBatch(batch)
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests that all contract code referenced by a state trie is migrated.
+func TestMigrateContractCode(t *testing.T) {
+	db, root, accounts := makeTestState()
+	if err := db.TrieDB().Commit(root, false); err != nil {
+		t.Fatalf("failed to commit state trie: %v", err)
+	}
+	src := db.TrieDB().DiskDB().(*ethdb.MemDatabase)
+	dst := ethdb.NewMemDatabase()
+
+	if err := MigrateContractCode(dst, src, root, nil); err != nil {
+		t.Fatalf("failed to migrate contract code: %v", err)
+	}
+	codes := 0
+	for i, acc := range accounts {
+		if len(acc.code) == 0 {
+			continue
+		}
+		codes++
+		if code := rawdb.ReadCodeWithPrefix(dst, crypto.Keccak256Hash(acc.code)); !bytes.Equal(code, acc.code) {
+			t.Errorf("account %d: code mismatch: have %x, want %x", i, code, acc.code)
+		}
+	}
+	if dst.Len() != codes {
+		t.Errorf("destination entry count mismatch: have %d, want %d", dst.Len(), codes)
+	}
+}
+
+// Tests that corrupt contract code is detected during migration, and that code
+// already migrated is not read again when a migration is resumed.
+func TestMigrateContractCodeCorrupt(t *testing.T) {
+	db, root, accounts := makeTestState()
+	if err := db.TrieDB().Commit(root, false); err != nil {
+		t.Fatalf("failed to commit state trie: %v", err)
+	}
+	src := db.TrieDB().DiskDB().(*ethdb.MemDatabase)
+	dst := ethdb.NewMemDatabase()
+	if err := MigrateContractCode(dst, src, root, nil); err != nil {
+		t.Fatalf("failed to migrate contract code: %v", err)
+	}
+	for _, acc := range accounts {
+		if len(acc.code) > 0 {
+			rawdb.WriteCode(src, crypto.Keccak256Hash(acc.code), []byte{0xde, 0xad})
+			break
+		}
+	}
+	if err := MigrateContractCode(ethdb.NewMemDatabase(), src, root, nil); err == nil {
+		t.Fatalf("corrupt contract code migrated")
+	}
+	if err := MigrateContractCode(dst, src, root, nil); err != nil {
+		t.Fatalf("failed to resume contract code migration: %v", err)
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"bytes"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 )
@@ -33,9 +35,21 @@ func NewStateSync(root common.Hash, database trie.DatabaseReader) *trie.Sync {
 			return err
 		}
 		syncer.AddSubTrie(obj.Root, 64, parent, nil)
-		syncer.AddRawEntry(common.BytesToHash(obj.CodeHash), 64, parent)
+		syncer.AddCodeEntry(common.BytesToHash(obj.CodeHash), 64, parent)
 		return nil
 	}
-	syncer = trie.NewSync(root, database, callback)
+	syncer = trie.NewSync(root, database, codeStore{}, callback)
 	return syncer
 }
+
+// codeStore stores the contract code retrieved by the state sync in the code
+// namespace of the chain database.
+type codeStore struct{}
+
+func (codeStore) HasCode(db trie.DatabaseReader, hash common.Hash) bool {
+	return rawdb.HasCode(db, hash)
+}
+
+func (codeStore) WriteCode(db ethdb.Putter, hash common.Hash, code []byte) {
+	rawdb.WriteCode(db, hash, code)
+}
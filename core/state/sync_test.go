@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/trie"
@@ -129,6 +130,64 @@ func TestEmptyStateSync(t *testing.T) {
 	}
 }
 
+// Tests that contract code is synced into the code namespace, and that code
+// already present there or under its legacy bare hash is not requested again.
+func TestStateSyncCodeNamespace(t *testing.T) {
+	srcDb, srcRoot, srcAccounts := makeTestState()
+
+	// Pre-store one code in each of the two locations
+	dstDb := ethdb.NewMemDatabase()
+	known := make(map[common.Hash]bool)
+	for _, acc := range srcAccounts {
+		if len(acc.code) == 0 {
+			continue
+		}
+		hash := crypto.Keccak256Hash(acc.code)
+		switch len(known) {
+		case 0:
+			rawdb.WriteCode(dstDb, hash, acc.code)
+		case 1:
+			dstDb.Put(hash[:], acc.code)
+		}
+		known[hash] = len(known) < 2
+	}
+	sched := NewStateSync(srcRoot, dstDb)
+	for queue := sched.Missing(0); len(queue) > 0; queue = sched.Missing(0) {
+		results := make([]trie.SyncResult, len(queue))
+		for i, hash := range queue {
+			if known[hash] {
+				t.Fatalf("known code %x requested", hash)
+			}
+			data, err := srcDb.TrieDB().Node(hash)
+			if err != nil {
+				data, err = srcDb.ContractCode(common.Hash{}, hash)
+			}
+			if err != nil {
+				t.Fatalf("failed to retrieve node data for %x", hash)
+			}
+			results[i] = trie.SyncResult{Hash: hash, Data: data}
+		}
+		if _, index, err := sched.Process(results); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		if index, err := sched.Commit(dstDb); err != nil {
+			t.Fatalf("failed to commit data #%d: %v", index, err)
+		}
+	}
+	for hash, preexisting := range known {
+		if preexisting {
+			continue
+		}
+		if code := rawdb.ReadCodeWithPrefix(dstDb, hash); len(code) == 0 {
+			t.Errorf("code %x not synced into the code namespace", hash)
+		}
+		if has, _ := dstDb.Has(hash[:]); has {
+			t.Errorf("code %x synced under its bare hash", hash)
+		}
+	}
+	checkStateAccounts(t, dstDb, srcRoot, srcAccounts)
+}
+
 // Tests that given a root hash, a state can sync iteratively on a single thread,
 // requesting retrieval tasks and returning all of them in one go.
 func TestIterativeStateSyncIndividual(t *testing.T) { testIterativeStateSync(t, 1) }
@@ -147,6 +206,9 @@ func testIterativeStateSync(t *testing.T, batch int) {
 		results := make([]trie.SyncResult, len(queue))
 		for i, hash := range queue {
 			data, err := srcDb.TrieDB().Node(hash)
+			if err != nil {
+				data, err = srcDb.ContractCode(common.Hash{}, hash)
+			}
 			if err != nil {
 				t.Fatalf("failed to retrieve node data for %x", hash)
 			}
@@ -180,6 +242,9 @@ func TestIterativeDelayedStateSync(t *testing.T) {
 		results := make([]trie.SyncResult, len(queue)/2+1)
 		for i, hash := range queue[:len(results)] {
 			data, err := srcDb.TrieDB().Node(hash)
+			if err != nil {
+				data, err = srcDb.ContractCode(common.Hash{}, hash)
+			}
 			if err != nil {
 				t.Fatalf("failed to retrieve node data for %x", hash)
 			}
@@ -220,6 +285,9 @@ func testIterativeRandomStateSync(t *testing.T, batch int) {
 		results := make([]trie.SyncResult, 0, len(queue))
 		for hash := range queue {
 			data, err := srcDb.TrieDB().Node(hash)
+			if err != nil {
+				data, err = srcDb.ContractCode(common.Hash{}, hash)
+			}
 			if err != nil {
 				t.Fatalf("failed to retrieve node data for %x", hash)
 			}
@@ -262,6 +330,9 @@ func TestIterativeRandomDelayedStateSync(t *testing.T) {
 			delete(queue, hash)
 
 			data, err := srcDb.TrieDB().Node(hash)
+			if err != nil {
+				data, err = srcDb.ContractCode(common.Hash{}, hash)
+			}
 			if err != nil {
 				t.Fatalf("failed to retrieve node data for %x", hash)
 			}
@@ -305,6 +376,9 @@ func TestIncompleteStateSync(t *testing.T) {
 		results := make([]trie.SyncResult, len(queue))
 		for i, hash := range queue {
 			data, err := srcDb.TrieDB().Node(hash)
+			if err != nil {
+				data, err = srcDb.ContractCode(common.Hash{}, hash)
+			}
 			if err != nil {
 				t.Fatalf("failed to retrieve node data for %x", hash)
 			}
@@ -339,6 +413,14 @@ func TestIncompleteStateSync(t *testing.T) {
 	}
 	// Sanity check that removing any node from the database is detected
 	for _, node := range added[1:] {
+		if code := rawdb.ReadCodeWithPrefix(dstDb, node); len(code) > 0 {
+			rawdb.DeleteCode(dstDb, node)
+			if err := checkStateConsistency(dstDb, added[0]); err == nil {
+				t.Fatalf("trie inconsistency not caught, missing code: %x", node)
+			}
+			rawdb.WriteCode(dstDb, node, code)
+			continue
+		}
 		key := node.Bytes()
 		value, _ := dstDb.Get(key)
 
@@ -28,6 +28,7 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 )
 
 func newTestLDB() (*ethdb.LDBDatabase, func()) {
@@ -212,3 +213,54 @@ func testParallelPutGet(db ethdb.Database, t *testing.T) {
 	}
 	pending.Wait()
 }
+
+// iteratee is the iteration capability shared by the database implementations.
+type iteratee interface {
+	ethdb.Database
+	NewIterator() iterator.Iterator
+	NewIteratorWithPrefix(prefix []byte) iterator.Iterator
+}
+
+func TestLDB_Iterator(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testIterator(db, t)
+}
+
+func TestMemoryDB_Iterator(t *testing.T) {
+	testIterator(ethdb.NewMemDatabase(), t)
+}
+
+func testIterator(db iteratee, t *testing.T) {
+	t.Parallel()
+
+	keys := []string{"b2", "a", "b1", "c", "", "b"}
+	for _, k := range keys {
+		if err := db.Put([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	collect := func(it iterator.Iterator) []string {
+		defer it.Release()
+
+		var have []string
+		for it.Next() {
+			if string(it.Value()) != "v"+string(it.Key()) {
+				t.Errorf("value mismatch for key %q: have %q", it.Key(), it.Value())
+			}
+			have = append(have, string(it.Key()))
+		}
+		return have
+	}
+	if have, want := collect(db.NewIterator()), []string{"", "a", "b", "b1", "b2", "c"}; fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("full iteration mismatch: have %q, want %q", have, want)
+	}
+	if have, want := collect(db.NewIteratorWithPrefix([]byte("b"))), []string{"b", "b1", "b2"}; fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("prefix iteration mismatch: have %q, want %q", have, want)
+	}
+	it := db.NewIterator()
+	defer it.Release()
+	if !it.Seek([]byte("b0")) || string(it.Key()) != "b1" {
+		t.Errorf("seek mismatch: have %q, want %q", it.Key(), "b1")
+	}
+}
@@ -17,10 +17,14 @@
 package ethdb
 
 import (
+	"bytes"
 	"errors"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 )
 
 /*
@@ -80,6 +84,28 @@ func (db *MemDatabase) Keys() [][]byte {
 	return keys
 }
 
+// NewIterator returns an iterator over a point-in-time copy of the database
+// content, ordered by key.
+func (db *MemDatabase) NewIterator() iterator.Iterator {
+	return db.NewIteratorWithPrefix(nil)
+}
+
+// NewIteratorWithPrefix returns an iterator over a point-in-time copy of the
+// database content with a particular prefix, ordered by key.
+func (db *MemDatabase) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var items kvs
+	for key, value := range db.db {
+		if strings.HasPrefix(key, string(prefix)) {
+			items = append(items, kv{k: []byte(key), v: common.CopyBytes(value)})
+		}
+	}
+	sort.Sort(items)
+	return iterator.NewArrayIterator(items)
+}
+
 func (db *MemDatabase) Delete(key []byte) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
@@ -101,6 +127,20 @@ type kv struct {
 	del  bool
 }
 
+// kvs is a key ordered list of key-value pairs, implementing both sort.Interface
+// and iterator.Array to back memory database iterators.
+type kvs []kv
+
+func (s kvs) Len() int           { return len(s) }
+func (s kvs) Less(i, j int) bool { return bytes.Compare(s[i].k, s[j].k) < 0 }
+func (s kvs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (s kvs) Search(key []byte) int {
+	return sort.Search(len(s), func(i int) bool { return bytes.Compare(s[i].k, key) >= 0 })
+}
+
+func (s kvs) Index(i int) (key, value []byte) { return s[i].k, s[i].v }
+
 type memBatch struct {
 	db     *MemDatabase
 	writes []kv
@@ -0,0 +1,142 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package remotedb provides read-only access to a key-value database hosted by
+// another process over RPC, so that data migrations can pull chain data from a
+// node's database on a different machine.
+//
+// The serving side is a plain rpc.Server which can be exposed over any of the
+// RPC transports (HTTP, WebSocket, IPC). As it grants unrestricted read access
+// to the whole database, it should never be exposed publicly. Geth itself does
+// not start such a server; it is up to the program owning the database.
+//
+// Only point lookups are supported: a remote database cannot be iterated, so it
+// can't be the source of migrations walking key ranges (MigrateSideChainBlocks,
+// MigratePreimages, CopyRange and friends). Failed calls are returned as errors,
+// so callers must not take every error for a missing key.
+package remotedb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Namespace is the RPC namespace the database is served under.
+const Namespace = "remotedb"
+
+// errReadOnly is returned when attempting to modify a remote database.
+var errReadOnly = errors.New("remote database is read-only")
+
+// Reader wraps the read methods of a database that can be served remotely.
+type Reader interface {
+	Has(key []byte) (bool, error)
+	Get(key []byte) ([]byte, error)
+}
+
+// API is the RPC service serving read requests from a local database.
+type API struct {
+	db Reader
+}
+
+// Has reports whether the given key is present in the database.
+func (api *API) Has(key hexutil.Bytes) (bool, error) {
+	return api.db.Has(key)
+}
+
+// Get retrieves the value stored under the given key.
+func (api *API) Get(key hexutil.Bytes) (hexutil.Bytes, error) {
+	return api.db.Get(key)
+}
+
+// NewServer creates an RPC server exposing read-only access to db.
+func NewServer(db Reader) (*rpc.Server, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName(Namespace, &API{db: db}); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Database is a read-only ethdb.Database backed by a database served remotely.
+// All write operations fail.
+type Database struct {
+	c *rpc.Client
+}
+
+// Dial connects to a remote database served at the given URL.
+func Dial(rawurl string) (*Database, error) {
+	c, err := rpc.DialContext(context.Background(), rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return New(c), nil
+}
+
+// New creates a remote database using the given RPC client.
+func New(c *rpc.Client) *Database {
+	return &Database{c}
+}
+
+// Has reports whether the given key is present in the remote database.
+func (db *Database) Has(key []byte) (bool, error) {
+	var has bool
+	if err := db.c.Call(&has, Namespace+"_has", hexutil.Bytes(key)); err != nil {
+		return false, err
+	}
+	return has, nil
+}
+
+// Get retrieves the value stored under the given key in the remote database.
+func (db *Database) Get(key []byte) ([]byte, error) {
+	var value hexutil.Bytes
+	if err := db.c.Call(&value, Namespace+"_get", hexutil.Bytes(key)); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Put implements ethdb.Putter, but always fails as the database is read-only.
+func (db *Database) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete implements ethdb.Deleter, but always fails as the database is read-only.
+func (db *Database) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// NewBatch implements ethdb.Database, returning a batch which rejects all writes.
+func (db *Database) NewBatch() ethdb.Batch {
+	return readOnlyBatch{}
+}
+
+// Close terminates the connection to the remote database.
+func (db *Database) Close() {
+	db.c.Close()
+}
+
+// readOnlyBatch is an ethdb.Batch rejecting all writes.
+type readOnlyBatch struct{}
+
+func (readOnlyBatch) Put(key []byte, value []byte) error { return errReadOnly }
+func (readOnlyBatch) Delete(key []byte) error            { return errReadOnly }
+func (readOnlyBatch) ValueSize() int                     { return 0 }
+func (readOnlyBatch) Write() error                       { return nil }
+func (readOnlyBatch) Reset()                             {}
@@ -0,0 +1,102 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package remotedb
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// newTestRemote serves the given database in-process and returns a remote
+// handle to it.
+func newTestRemote(t *testing.T, db ethdb.Database) *Database {
+	server, err := NewServer(db)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	return New(rpc.DialInProc(server))
+}
+
+// Tests that the remote database serves reads and rejects writes.
+func TestRemoteAccess(t *testing.T) {
+	local := ethdb.NewMemDatabase()
+	local.Put([]byte("key"), []byte("value"))
+	local.Put([]byte("empty"), nil)
+
+	remote := newTestRemote(t, local)
+	defer remote.Close()
+
+	if value, err := remote.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("value mismatch: have %q, %v, want %q", value, err, "value")
+	}
+	if value, err := remote.Get([]byte("empty")); err != nil || len(value) != 0 {
+		t.Errorf("empty value mismatch: have %q, %v", value, err)
+	}
+	if _, err := remote.Get([]byte("missing")); err == nil {
+		t.Errorf("missing key retrieved")
+	}
+	if has, err := remote.Has([]byte("key")); err != nil || !has {
+		t.Errorf("existing key not reported: %v, %v", has, err)
+	}
+	if has, err := remote.Has([]byte("missing")); err != nil || has {
+		t.Errorf("missing key reported: %v, %v", has, err)
+	}
+	if err := remote.Put([]byte("key"), []byte("other")); err != errReadOnly {
+		t.Errorf("write error mismatch: have %v, want %v", err, errReadOnly)
+	}
+	if err := remote.Delete([]byte("key")); err != errReadOnly {
+		t.Errorf("delete error mismatch: have %v, want %v", err, errReadOnly)
+	}
+	if value, _ := local.Get([]byte("key")); !bytes.Equal(value, []byte("value")) {
+		t.Errorf("local database modified: have %q, want %q", value, "value")
+	}
+}
+
+// Tests that blocks can be migrated out of a remote database.
+func TestRemoteMigration(t *testing.T) {
+	local := ethdb.NewMemDatabase()
+
+	var parent common.Hash
+	for i := 0; i < 4; i++ {
+		block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(int64(i)), ParentHash: parent})
+		rawdb.WriteBlock(local, block)
+		rawdb.WriteCanonicalHash(local, block.Hash(), block.NumberU64())
+		rawdb.WriteTd(local, block.Hash(), block.NumberU64(), big.NewInt(int64(i+1)))
+		parent = block.Hash()
+	}
+	remote := newTestRemote(t, local)
+	defer remote.Close()
+
+	dst := ethdb.NewMemDatabase()
+	if err := rawdb.MigrateCanonicalBlocks(dst, remote, 0, 3, false, nil); err != nil {
+		t.Fatalf("failed to migrate from remote database: %v", err)
+	}
+	report, err := rawdb.VerifyMigration(dst, local, 0, 3)
+	if err != nil {
+		t.Fatalf("failed to verify migration: %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("migration from remote database incomplete: %v", report.Issues)
+	}
+}
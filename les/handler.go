@@ -604,7 +604,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 					if err != nil {
 						continue
 					}
-					code, _ := statedb.Database().TrieDB().Node(common.BytesToHash(account.CodeHash))
+					code, _ := statedb.Database().ContractCode(common.BytesToHash(req.AccKey), common.BytesToHash(account.CodeHash))
 
 					data = append(data, code)
 					if bytes += len(code); bytes >= softResponseLimit {
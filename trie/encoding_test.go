@@ -43,6 +43,10 @@ func TestHexCompact(t *testing.T) {
 			t.Errorf("compactToHex(%x) -> %x, want %x", test.compact, h, test.hex)
 		}
 	}
+	// Empty compact keys are invalid, but must not crash the decoder.
+	if h := compactToHex([]byte{}); len(h) != 0 {
+		t.Errorf("compactToHex([]) -> %x, want empty", h)
+	}
 }
 
 func TestHexKeybytes(t *testing.T) {
@@ -0,0 +1,87 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build gofuzz
+
+package trie
+
+import "bytes"
+
+// Fuzz implements a go-fuzz fuzzer method to test the decoding of trie nodes
+// and the key encodings they are built from. The first byte of the input picks
+// the target, the rest is fed into it. A seed corpus of node encodings and keys
+// taken from a small test trie lives in testdata/fuzz/corpus.
+func Fuzz(data []byte) int {
+	if len(data) == 0 {
+		return -1
+	}
+	switch data[0] % 3 {
+	case 0:
+		return fuzzDecodeNode(data[1:])
+	case 1:
+		return fuzzCompact(data[1:])
+	default:
+		return fuzzHex(data[1:])
+	}
+}
+
+// fuzzDecodeNode implements a go-fuzz fuzzer method to test the decoding of
+// arbitrary, potentially malformed node blobs read from disk.
+func fuzzDecodeNode(data []byte) int {
+	n, err := decodeNode(nil, data, 0)
+	if err != nil {
+		return 0
+	}
+	if n, ok := n.(*shortNode); ok {
+		if have := compactToHex(hexToCompact(n.Key)); !bytes.Equal(have, n.Key) {
+			panic("short node key mismatch")
+		}
+	}
+	return 1
+}
+
+// fuzzCompact implements a go-fuzz fuzzer method to test that converting an
+// arbitrary compact key into hex and back yields a stable result.
+func fuzzCompact(data []byte) int {
+	hex := compactToHex(data)
+	if have := compactToHex(hexToCompact(hex)); !bytes.Equal(have, hex) {
+		panic("compact key mismatch")
+	}
+	return 0
+}
+
+// fuzzHex implements a go-fuzz fuzzer method to test the hex key conversions
+// on arbitrary nibble sequences, with and without terminator.
+func fuzzHex(data []byte) int {
+	if len(data) == 0 {
+		return -1
+	}
+	key := keybytesToHex(data[1:])
+	if have := hexToKeybytes(key); !bytes.Equal(have, data[1:]) {
+		panic("key bytes mismatch")
+	}
+	hex := make([]byte, 0, len(data))
+	for _, b := range data[1:] {
+		hex = append(hex, b&0x0f)
+	}
+	if data[0]&1 == 1 {
+		hex = append(hex, 16)
+	}
+	if have := compactToHex(hexToCompact(hex)); !bytes.Equal(have, hex) {
+		panic("hex key mismatch")
+	}
+	return 0
+}
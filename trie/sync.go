@@ -37,7 +37,8 @@ var ErrAlreadyProcessed = errors.New("already processed")
 type request struct {
 	hash common.Hash // Hash of the node data content to retrieve
 	data []byte      // Data content of the node, cached until all subtrees complete
-	raw  bool        // Whether this is a raw entry (code) or a trie node
+	raw  bool        // Whether this is a raw entry or a trie node
+	code bool        // Whether this is a contract code entry
 
 	parents []*request // Parent state nodes referencing this entry (notify all upon completion)
 	depth   int        // Depth level within the trie the node is located to prioritise DFS
@@ -53,11 +54,24 @@ type SyncResult struct {
 	Data []byte      // Data content of the retrieved node
 }
 
+// CodeStore abstracts the storage of contract code retrieved by the sync, which
+// is kept apart from the trie nodes in its own key namespace. The trie package
+// doesn't know about the database schema, so the code layout is provided by the
+// user of the sync.
+type CodeStore interface {
+	// HasCode reports whether the code with the given hash is already stored.
+	HasCode(db DatabaseReader, hash common.Hash) bool
+
+	// WriteCode stores a code blob under its hash.
+	WriteCode(db ethdb.Putter, hash common.Hash, code []byte)
+}
+
 // syncMemBatch is an in-memory buffer of successfully downloaded but not yet
 // persisted data items.
 type syncMemBatch struct {
 	batch map[common.Hash][]byte // In-memory membatch of recently completed items
 	order []common.Hash          // Order of completion to prevent out-of-order data loss
+	codes map[common.Hash][]byte // In-memory membatch of recently completed codes
 }
 
 // newSyncMemBatch allocates a new memory-buffer for not-yet persisted trie nodes.
@@ -65,6 +79,7 @@ func newSyncMemBatch() *syncMemBatch {
 	return &syncMemBatch{
 		batch: make(map[common.Hash][]byte),
 		order: make([]common.Hash, 0, 256),
+		codes: make(map[common.Hash][]byte),
 	}
 }
 
@@ -73,17 +88,23 @@ func newSyncMemBatch() *syncMemBatch {
 // and reconstructs the trie step by step until all is done.
 type Sync struct {
 	database DatabaseReader           // Persistent database to check for existing entries
+	codes    CodeStore                // Storage of the contract code entries
 	membatch *syncMemBatch            // Memory buffer to avoid frequent database writes
 	requests map[common.Hash]*request // Pending requests pertaining to a key hash
+	codeReqs map[common.Hash]*request // Pending requests pertaining to a code hash
 	queue    *prque.Prque             // Priority queue with the pending requests
 }
 
-// NewSync creates a new trie data download scheduler.
-func NewSync(root common.Hash, database DatabaseReader, callback LeafCallback) *Sync {
+// NewSync creates a new trie data download scheduler. The code store is only
+// needed if contract code entries are scheduled via AddCodeEntry, it may be nil
+// otherwise.
+func NewSync(root common.Hash, database DatabaseReader, codes CodeStore, callback LeafCallback) *Sync {
 	ts := &Sync{
 		database: database,
+		codes:    codes,
 		membatch: newSyncMemBatch(),
 		requests: make(map[common.Hash]*request),
+		codeReqs: make(map[common.Hash]*request),
 		queue:    prque.New(nil),
 	}
 	ts.AddSubTrie(root, 0, common.Hash{}, callback)
@@ -155,6 +176,38 @@ func (s *Sync) AddRawEntry(hash common.Hash, depth int, parent common.Hash) {
 	s.schedule(req)
 }
 
+// AddCodeEntry schedules the direct retrieval of a contract code that should not
+// be interpreted as a trie node, but rather accepted and stored into the code
+// namespace of the database via the code store of the sync.
+func (s *Sync) AddCodeEntry(hash common.Hash, depth int, parent common.Hash) {
+	// Short circuit if the entry is empty or already known
+	if hash == emptyState {
+		return
+	}
+	if _, ok := s.membatch.codes[hash]; ok {
+		return
+	}
+	if s.codes.HasCode(s.database, hash) {
+		return
+	}
+	// Assemble the new sub-trie sync request
+	req := &request{
+		hash:  hash,
+		code:  true,
+		depth: depth,
+	}
+	// If this sub-trie has a designated parent, link them together
+	if parent != (common.Hash{}) {
+		ancestor := s.requests[parent]
+		if ancestor == nil {
+			panic(fmt.Sprintf("code-entry ancestor not found: %x", parent))
+		}
+		ancestor.deps++
+		req.parents = append(req.parents, ancestor)
+	}
+	s.schedule(req)
+}
+
 // Missing retrieves the known missing nodes from the trie for retrieval.
 func (s *Sync) Missing(max int) []common.Hash {
 	requests := []common.Hash{}
@@ -171,9 +224,20 @@ func (s *Sync) Process(results []SyncResult) (bool, int, error) {
 	committed := false
 
 	for i, item := range results {
+		// If the item is a code request, commit directly. The same blob may also
+		// be requested as a trie node, so check for that too.
+		code := s.codeReqs[item.Hash]
+		if code != nil {
+			code.data = item.Data
+			s.commit(code)
+			committed = true
+		}
 		// If the item was not requested, bail out
 		request := s.requests[item.Hash]
 		if request == nil {
+			if code != nil {
+				continue
+			}
 			return committed, i, ErrNotRequested
 		}
 		if request.data != nil {
@@ -214,13 +278,17 @@ func (s *Sync) Process(results []SyncResult) (bool, int, error) {
 // Commit flushes the data stored in the internal membatch out to persistent
 // storage, returning the number of items written and any occurred error.
 func (s *Sync) Commit(dbw ethdb.Putter) (int, error) {
-	// Dump the membatch into a database dbw
+	// Dump the membatch into a database dbw. Codes go first, as they complete
+	// before the trie nodes referencing them.
+	for hash, code := range s.membatch.codes {
+		s.codes.WriteCode(dbw, hash, code)
+	}
 	for i, key := range s.membatch.order {
 		if err := dbw.Put(key[:], s.membatch.batch[key]); err != nil {
-			return i, err
+			return len(s.membatch.codes) + i, err
 		}
 	}
-	written := len(s.membatch.order)
+	written := len(s.membatch.order) + len(s.membatch.codes)
 
 	// Drop the membatch data and return
 	s.membatch = newSyncMemBatch()
@@ -229,21 +297,25 @@ func (s *Sync) Commit(dbw ethdb.Putter) (int, error) {
 
 // Pending returns the number of state entries currently pending for download.
 func (s *Sync) Pending() int {
-	return len(s.requests)
+	return len(s.requests) + len(s.codeReqs)
 }
 
 // schedule inserts a new state retrieval request into the fetch queue. If there
 // is already a pending request for this node, the new request will be discarded
 // and only a parent reference added to the old one.
 func (s *Sync) schedule(req *request) {
+	reqset := s.requests
+	if req.code {
+		reqset = s.codeReqs
+	}
 	// If we're already requesting this node, add a new reference and stop
-	if old, ok := s.requests[req.hash]; ok {
+	if old, ok := reqset[req.hash]; ok {
 		old.parents = append(old.parents, req.parents...)
 		return
 	}
 	// Schedule the request for future retrieval
 	s.queue.Push(req.hash, int64(req.depth))
-	s.requests[req.hash] = req
+	reqset[req.hash] = req
 }
 
 // children retrieves all the missing children of a state trie entry for future
@@ -312,10 +384,14 @@ func (s *Sync) children(req *request, object node) ([]*request, error) {
 // committed themselves.
 func (s *Sync) commit(req *request) (err error) {
 	// Write the node content to the membatch
-	s.membatch.batch[req.hash] = req.data
-	s.membatch.order = append(s.membatch.order, req.hash)
-
-	delete(s.requests, req.hash)
+	if req.code {
+		s.membatch.codes[req.hash] = req.data
+		delete(s.codeReqs, req.hash)
+	} else {
+		s.membatch.batch[req.hash] = req.data
+		s.membatch.order = append(s.membatch.order, req.hash)
+		delete(s.requests, req.hash)
+	}
 
 	// Check all parents for completion
 	for _, parent := range req.parents {
@@ -467,6 +467,39 @@ func runRandTest(rt randTest) bool {
 	return true
 }
 
+// shrink reduces a failing test to a minimal sequence of steps that still fails
+// according to run, by repeatedly removing chunks of steps, halving the chunk
+// size whenever no chunk can be dropped. The returned steps carry the errors of
+// the last failing run.
+func (rt randTest) shrink(run func(randTest) bool) randTest {
+	try := func(steps randTest) bool {
+		for i := range steps {
+			steps[i].err = nil
+		}
+		return !run(steps)
+	}
+	for chunk := len(rt) / 2; chunk > 0; {
+		removed := false
+		for start := 0; start+chunk <= len(rt); {
+			candidate := make(randTest, 0, len(rt)-chunk)
+			candidate = append(candidate, rt[:start]...)
+			candidate = append(candidate, rt[start+chunk:]...)
+			if try(candidate) {
+				rt, removed = candidate, true
+				continue
+			}
+			start += chunk
+		}
+		if !removed {
+			chunk /= 2
+		} else if chunk > len(rt)/2 {
+			chunk = len(rt) / 2
+		}
+	}
+	try(rt)
+	return rt
+}
+
 func checkCacheInvariant(n, parent node, parentCachegen uint16, parentDirty bool, depth int) error {
 	var children []node
 	var flag nodeFlag
@@ -504,12 +537,45 @@ func checkCacheInvariant(n, parent node, parentCachegen uint16, parentDirty bool
 func TestRandom(t *testing.T) {
 	if err := quick.Check(runRandTest, nil); err != nil {
 		if cerr, ok := err.(*quick.CheckError); ok {
-			t.Fatalf("random test iteration %d failed: %s", cerr.Count, spew.Sdump(cerr.In))
+			rt := cerr.In[0].(randTest)
+			minimal := append(randTest{}, rt...).shrink(runRandTest)
+			t.Fatalf("random test iteration %d failed, minimal sequence (%d of %d steps): %s", cerr.Count, len(minimal), len(rt), spew.Sdump(minimal))
 		}
 		t.Fatal(err)
 	}
 }
 
+// Tests that failing random tests are reduced to the steps causing the failure.
+func TestRandomShrink(t *testing.T) {
+	var rt randTest
+	for i := 0; i < 100; i++ {
+		rt = append(rt, randTestStep{op: opUpdate, key: []byte{byte(i)}, value: []byte{byte(i)}})
+	}
+	// Fail whenever keys 13 and 57 are both inserted, in order
+	run := func(steps randTest) bool {
+		seen := false
+		for i, step := range steps {
+			switch step.key[0] {
+			case 13:
+				seen = true
+			case 57:
+				if seen {
+					steps[i].err = errors.New("boom")
+					return false
+				}
+			}
+		}
+		return true
+	}
+	minimal := rt.shrink(run)
+	if len(minimal) != 2 || minimal[0].key[0] != 13 || minimal[1].key[0] != 57 {
+		t.Fatalf("shrunk sequence mismatch: %s", spew.Sdump(minimal))
+	}
+	if minimal[1].err == nil {
+		t.Fatalf("failing step not recorded")
+	}
+}
+
 func BenchmarkGet(b *testing.B)      { benchGet(b, false) }
 func BenchmarkGetDB(b *testing.B)    { benchGet(b, true) }
 func BenchmarkUpdateBE(b *testing.B) { benchUpdate(b, binary.BigEndian) }
@@ -591,6 +657,61 @@ func BenchmarkHash(b *testing.B) {
 	trie.Hash()
 }
 
+func BenchmarkCommit(b *testing.B)   { benchCommit(b, false) }
+func BenchmarkCommitDB(b *testing.B) { benchCommit(b, true) }
+
+// Benchmarks committing the trie into its node database and flushing it to disk.
+// Similarly to hashing, b.N is the number of random keys inserted into the trie
+// before measuring a single commit.
+func benchCommit(b *testing.B, disk bool) {
+	// Make the random benchmark deterministic
+	random := rand.New(rand.NewSource(0))
+
+	triedb := NewDatabase(ethdb.NewMemDatabase())
+	if disk {
+		var dir string
+		dir, triedb = tempDB()
+		defer os.RemoveAll(dir)
+		defer triedb.diskdb.(*ethdb.LDBDatabase).Close()
+	}
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < b.N; i++ {
+		k := make([]byte, 32)
+		random.Read(k)
+		trie.Update(k, k)
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	root, _ := trie.Commit(nil)
+	triedb.Commit(root, false)
+}
+
+// Benchmarks reading random keys from a trie freshly reopened from a leveldb
+// backed database, so that every lookup has to resolve nodes from disk.
+func BenchmarkGetReopened(b *testing.B) {
+	dir, triedb := tempDB()
+	defer os.RemoveAll(dir)
+	defer triedb.diskdb.(*ethdb.LDBDatabase).Close()
+
+	trie, _ := New(common.Hash{}, triedb)
+	keys := make([][]byte, benchElemCount)
+	for i := range keys {
+		keys[i] = crypto.Keccak256(big.NewInt(int64(i)).Bytes())
+		trie.Update(keys[i], keys[i])
+	}
+	root, _ := trie.Commit(nil)
+	triedb.Commit(root, false)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%benchElemCount == 0 {
+			trie, _ = New(root, NewDatabase(triedb.diskdb))
+		}
+		trie.Get(keys[i%benchElemCount])
+	}
+}
+
 func tempDB() (string, *Database) {
 	dir, err := ioutil.TempDir("", "trie-bench")
 	if err != nil {